@@ -14,7 +14,7 @@ require (
 	gopkg.in/yaml.v1 v1.0.0-20140924161607-9f9df34309c0
 )
 
-require golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+require github.com/mattn/go-sqlite3 v1.14.16
 
 require (
 	github.com/josharian/intern v1.0.0 // indirect
@@ -26,7 +26,7 @@ require (
 	github.com/arduino/go-properties-orderedmap v1.6.0 // indirect
 	github.com/arduino/go-timeutils v0.0.0-20171220113728-d1dd9e313b1b // indirect
 	github.com/arduino/go-win32-utils v0.0.0-20180330194947-ed041402e83b // indirect
-	github.com/cmaglie/pb v1.0.27 // indirect
+	github.com/cmaglie/pb v1.0.27
 	github.com/codeclysm/extract/v3 v3.0.2 // indirect
 	github.com/emirpasic/gods v1.12.0 // indirect
 	github.com/fsnotify/fsnotify v1.5.1 // indirect
@@ -67,7 +67,7 @@ require (
 	go.bug.st/relaxed-semver v0.9.0
 	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5 // indirect
 	golang.org/x/net v0.0.0-20210813160813-60bc85c4be6d // indirect
-	golang.org/x/sys v0.0.0-20211205182925-97ca703d548d // indirect
+	golang.org/x/sys v0.0.0-20211205182925-97ca703d548d
 	golang.org/x/text v0.3.7 // indirect
 	google.golang.org/genproto v0.0.0-20211208223120-3a66f561d7aa // indirect
 	google.golang.org/grpc v1.44.0 // indirect
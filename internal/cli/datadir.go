@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/alranel/arduino-testlib/internal/store"
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/alranel/arduino-testlib/pkg/test"
+	"github.com/spf13/cobra"
+)
+
+// lockDatadir takes an exclusive lock on a datadir before writing to it, so
+// that concurrent runs can't overwrite each other's results. The returned
+// function releases the lock.
+func lockDatadir(datadirPath string) func() {
+	os.MkdirAll(datadirPath, os.ModePerm)
+	unlock, err := util.LockDirectory(datadirPath, true)
+	if err != nil {
+		util.Fatalf("Could not lock datadir %s: %v\n", datadirPath, err)
+	}
+	return unlock
+}
+
+// lockDatadirShared takes a shared lock on a datadir before reading it. Since
+// results files are replaced atomically, reading while another process is
+// writing is safe, so only a warning is printed in that case. The returned
+// function releases the lock.
+func lockDatadirShared(datadirPath string) func() {
+	unlock, err := util.LockDirectory(datadirPath, false)
+	if err != nil {
+		if err == util.ErrLocked {
+			fmt.Fprintf(os.Stderr, "Warning: datadir %s is being written by another process, results may be incomplete\n", datadirPath)
+		}
+		return func() {}
+	}
+	return unlock
+}
+
+// openStore opens the result store of a datadir. The store already holding
+// results in the datadir is used, and it must match --store if given, so
+// that a wrong --store doesn't start an empty store next to it; new datadirs
+// use the backend selected with --store.
+func openStore(cmd *cobra.Command, datadirPath string) store.Store {
+	kind, _ := cmd.Flags().GetString("store")
+	if existing := store.Existing(datadirPath); existing != "" && existing != kind {
+		if cmd.Flags().Changed("store") {
+			util.Fatalf("Datadir %s holds a %s store, but --store %s was given; use merge to convert it\n", datadirPath, existing, kind)
+		}
+		kind = existing
+	}
+	s, err := store.Open(kind, datadirPath)
+	if err != nil {
+		util.Fatalf("Could not open result store in %s: %v\n", datadirPath, err)
+	}
+	return s
+}
+
+// getResults reads the results of a library that are going to be updated.
+// Corrupt results are reported with a warning and treated as missing, while
+// other errors are fatal to avoid overwriting results that couldn't be read.
+func getResults(s store.Store, lib string) test.TestResults {
+	tr, err := s.Get(lib)
+	if errors.Is(err, test.ErrCorruptResults) {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	} else if err != nil {
+		util.Fatalf("Could not read test results: %v\n", err)
+	}
+	return tr
+}
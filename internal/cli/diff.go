@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alranel/arduino-testlib/internal/report"
+	"github.com/alranel/arduino-testlib/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff --base /path/to/dir --head /path/to/dir",
+	Short: "Compare the results of two datadirs",
+	Long:  `This command compares the last results of the library/board pairs tested in two datadirs, for instance the runs of two releases of a core, and reports the pairs whose inclusion or example result changed`,
+	Run:   runDiff,
+}
+
+func init() {
+	diffCmd.Flags().String("base", "", "The datadir with the results to compare with")
+	diffCmd.Flags().String("head", "", "The datadir with the new results")
+	reportCmd.AddCommand(diffCmd)
+}
+
+func runDiff(cmd *cobra.Command, cliArguments []string) {
+	basePath, _ := cmd.Flags().GetString("base")
+	headPath, _ := cmd.Flags().GetString("head")
+	if basePath == "" || headPath == "" {
+		fmt.Fprintf(os.Stderr, "Missing required --base and --head options\n")
+		os.Exit(1)
+	}
+	outputDir, _ := cmd.Flags().GetString("output")
+
+	// The store of each datadir is detected from its contents
+	var stores []store.Store
+	for _, datadirPath := range []string{basePath, headPath} {
+		if info, err := os.Stat(datadirPath); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Could not read datadir %s\n", datadirPath)
+			os.Exit(1)
+		}
+		defer lockDatadirShared(datadirPath)()
+		s, err := store.Open(store.Detect(datadirPath), datadirPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open result store in %s: %v\n", datadirPath, err)
+			os.Exit(1)
+		}
+		defer s.Close()
+		stores = append(stores, s)
+	}
+
+	report.Diff(stores[0], stores[1], basePath, headPath, outputDir)
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/alranel/arduino-testlib/internal/compare"
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/alranel/arduino-testlib/pkg/test"
+	"github.com/spf13/cobra"
+)
+
+// exitRegressions is the exit code used when the regressions exceed the
+// thresholds, distinguishing them from errors.
+const exitRegressions = 2
+
+// addGateFlags registers the flags comparing the results with a baseline.
+func addGateFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("baseline", "", "Compare the results with a baseline (a datadir or a results JSON file) and exit with code 2 on regressions")
+	cmd.PersistentFlags().Int("max-regressions", 0, "How many regressions (PASS to FAIL, or an example newly failing) are tolerated before failing")
+	cmd.PersistentFlags().Int("max-new-fail-claims", 0, "How many new FAIL_CLAIM library/board pairs are tolerated before failing")
+}
+
+// gate compares the results with the --baseline, if any.
+type gate struct {
+	path       string
+	baseline   compare.Baseline
+	thresholds compare.Thresholds
+	mu         sync.Mutex
+	summary    compare.Summary
+}
+
+// openGate opens the --baseline, returning nil if none was supplied.
+func openGate(cmd *cobra.Command) *gate {
+	baselinePath, _ := cmd.Flags().GetString("baseline")
+	if baselinePath == "" {
+		return nil
+	}
+	baseline, err := compare.OpenBaseline(baselinePath)
+	if err != nil {
+		util.Fatalf("Could not open baseline: %v\n", err)
+	}
+	g := &gate{path: baselinePath, baseline: baseline}
+	g.thresholds.MaxRegressions, _ = cmd.Flags().GetInt("max-regressions")
+	g.thresholds.MaxNewFailClaims, _ = cmd.Flags().GetInt("max-new-fail-claims")
+	return g
+}
+
+// Base returns the baseline results of a library. They must be read before
+// testing it, as the baseline can be the datadir storing the new results.
+func (g *gate) Base(lib string) test.TestResults {
+	if lib == "" {
+		return test.TestResults{}
+	}
+	base, err := g.baseline.Get(lib)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return base
+}
+
+// Check compares the results of the given FQBNs with the baseline ones. It
+// is safe to call from multiple goroutines.
+func (g *gate) Check(base test.TestResults, tr test.TestResults, fqbns []string) {
+	changes := compare.Compare(base, tr, fqbns)
+	g.mu.Lock()
+	g.summary.Add(changes...)
+	g.mu.Unlock()
+}
+
+// Finish prints the summary of the comparison and exits with code 2 if the
+// thresholds are exceeded.
+func (g *gate) Finish(w io.Writer) {
+	s := &g.summary
+	var listed int
+	for _, c := range s.Changes {
+		switch c.Kind {
+		case compare.Regression, compare.ExampleRegression, compare.NewFailClaim:
+			if listed == 0 {
+				fmt.Fprintf(w, "Regressions compared to %s:\n", g.path)
+			}
+			fmt.Fprintf(w, "  %s\n", c)
+			listed++
+		}
+	}
+	fmt.Fprintf(w, "%d regressions (max %d), %d new FAIL_CLAIM (max %d), %d fixed\n",
+		s.Regressions(), g.thresholds.MaxRegressions,
+		s.Count(compare.NewFailClaim), g.thresholds.MaxNewFailClaims,
+		s.Count(compare.Fix, compare.ExampleFix))
+	if s.Exceeds(g.thresholds) {
+		util.Exit(exitRegressions)
+	}
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alranel/arduino-testlib/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc --datadir /path/to/dir",
+	Short: "Remove unused logs",
+	Long:  `This command removes the stored compilation logs that are no longer referenced by any test result, and optionally drops the logs of passing builds older than a retention period`,
+	Run:   runGC,
+}
+
+func init() {
+	gcCmd.PersistentFlags().Duration("passing-logs-retention", 0, "Drop the logs of passing builds older than this (eg. 720h); by default they are kept forever")
+	rootCmd.AddCommand(gcCmd)
+}
+
+func runGC(cmd *cobra.Command, cliArguments []string) {
+	// Check if a --datadir was supplied
+	datadirPath, _ := cmd.Flags().GetString("datadir")
+	if datadirPath == "" {
+		fmt.Fprintf(os.Stderr, "Missing required --datadir option\n")
+		os.Exit(1)
+	}
+	retention, _ := cmd.Flags().GetDuration("passing-logs-retention")
+
+	defer lockDatadir(datadirPath)()
+	results := openStore(cmd, datadirPath)
+	defer results.Close()
+
+	stats, err := store.GC(results, retention)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Garbage collection failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Dropped %d logs of passing builds, removed %d stored logs\n", stats.DroppedLogs, stats.RemovedLogs)
+}
@@ -2,31 +2,37 @@ package cli
 
 import (
 	"archive/zip"
-	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/alranel/arduino-testlib/internal/configuration"
+	"github.com/alranel/arduino-testlib/internal/download"
+	"github.com/alranel/arduino-testlib/internal/libindex"
+	"github.com/alranel/arduino-testlib/internal/util"
 	"github.com/arduino/arduino-cli/arduino/utils"
 	"github.com/spf13/cobra"
-	semver "go.bug.st/relaxed-semver"
 	"gopkg.in/ini.v1"
 )
 
 var installallCmd = &cobra.Command{
-	Use:   "installall",
+	Use:   "installall [LIB_GLOB...]",
 	Short: "Install all the Arduino libraries",
 	Long:  `This command performs a batch install/upgrade of all the libraries in the Arduino Library Manager`,
 	Run:   runInstallall,
 }
 
 func init() {
+	installallCmd.PersistentFlags().IntP("threads", "j", 4, "How many parallel downloads to run")
+	installallCmd.PersistentFlags().Int("retries", 3, "How many times a failed download is retried")
+	addLibraryFilterFlags(installallCmd)
+	installallCmd.PersistentFlags().Bool("purge", false, "Delete libraries removed from the index instead of moving them to the quarantine directory")
 	rootCmd.AddCommand(installallCmd)
 }
 
@@ -48,66 +54,281 @@ func runInstallall(cmd *cobra.Command, cliArguments []string) {
 		}
 	*/
 
-	// Read the library index
-	type library struct {
-		Name, Version, URL, ArchiveFileName string
+	// Read the library index and find the last version of each library
+	indexSource := path.Join(configuration.CLIDataDir, "data/library_index.json")
+	if configuration.Mirror != "" {
+		indexSource = util.MirrorURL("library_index.json")
 	}
-	libraries := make(map[string]library)
-	var jsonData struct{ Libraries []library }
+	index, err := libindex.LoadWithAdditional(indexSource, configuration.AdditionalLibraryIndexes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read library index: %v\n", err)
+		os.Exit(1)
+	}
+	libraries := index.Latest()
+	selected := selectLibraries(cmd, cliArguments, libraries)
 
-	jsonFile, err := os.Open(path.Join(configuration.CLIDataDir, "data/library_index.json"))
+	// Read the manifest of the previous sync
+	manifest, err := libindex.LoadManifest(util.ManifestPath())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to read library_index.json: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Unable to read library manifest: %v\n", err)
 		os.Exit(1)
 	}
-	defer jsonFile.Close()
-	byteValue, _ := ioutil.ReadAll(jsonFile)
-	json.Unmarshal(byteValue, &jsonData)
+	changelog := libindex.Changelog{SyncedAt: time.Now()}
+
+	// Find the libraries that need to be downloaded
+	downloadsDir := path.Join(configuration.CLIDataDir, "downloads/libraries")
+	os.MkdirAll(downloadsDir, os.ModePerm)
+	os.MkdirAll(util.LibrariesDirectory(), os.ModePerm)
+	var jobs []download.Job
+	pending := make(map[string]libindex.Library) // archive path => library
+	previousVersions := make(map[string]string)  // name => version on disk
+	for libName, lib := range selected {
+		libPath := util.LibPathFromName(libName)
+		installedVersion := ""
+		if _, err := os.Stat(libPath); err == nil {
+			installedVersion = libraryVersion(libPath)
+		}
 
-	// Find last version for each library
-	for _, lib := range jsonData.Libraries {
-		if l, ok := libraries[lib.Name]; ok {
-			if semver.ParseRelaxed(lib.Version).GreaterThan(semver.ParseRelaxed(l.Version)) {
-				libraries[lib.Name] = lib
+		// Check if we already have this version and skip download
+		if entry, ok := manifest.Libraries[libName]; ok {
+			if installedVersion == lib.Version && entry.Version == lib.Version && entry.Checksum == lib.Checksum {
+				continue
 			}
-		} else {
-			libraries[lib.Name] = lib
+		} else if installedVersion == lib.Version {
+			// Library installed before the manifest existed: adopt it
+			archivePath := path.Join(downloadsDir, lib.ArchiveFileName)
+			manifest.Libraries[libName] = adoptedEntry(lib, archivePath, changelog.SyncedAt)
+			continue
 		}
+
+		job := download.Job{
+			URL:      lib.URL,
+			Path:     path.Join(downloadsDir, lib.ArchiveFileName),
+			Checksum: lib.Checksum,
+			Size:     lib.Size,
+		}
+		jobs = append(jobs, job)
+		pending[job.Path] = lib
+		previousVersions[libName] = installedVersion
 	}
+	fmt.Printf("%d libraries to install or update\n", len(jobs))
 
 	// Download and unzip libraries
-	os.MkdirAll(path.Join(configuration.CLIDataDir, "downloads/libraries"), os.ModePerm)
-	os.MkdirAll(path.Join(configuration.CLIUserDir, "libraries"), os.ModePerm)
-	for libName, lib := range libraries {
-		// Check if we already have this version and skip download
-		libPath := path.Join(configuration.CLIUserDir, "libraries", utils.SanitizeName(libName))
-		if _, err := os.Stat(libPath); !os.IsNotExist(err) {
-			properties, err := ini.Load(path.Join(libPath, "library.properties"))
-			if err != nil {
-				if properties.Section("").Key("version").String() == lib.Version {
-					fmt.Printf("Skipping %s@%s\n", libName, lib.Version)
-					continue
-				}
+	threads, _ := cmd.Flags().GetInt("threads")
+	retries, _ := cmd.Flags().GetInt("retries")
+	var mutex sync.Mutex
+	failures := make(map[string]error) // name@version => error
+	download.All(jobs, threads, retries, func(job download.Job, cached bool, err error) {
+		lib := pending[job.Path]
+		nameAndVersion := lib.Name + "@" + lib.Version
+		removed := false
+		if err == nil {
+			if cached {
+				fmt.Printf("Installing %s (cached archive)\n", nameAndVersion)
+			} else {
+				fmt.Printf("Installing %s\n", nameAndVersion)
 			}
-		}
 
-		fmt.Printf("Installing %s@%s\n", libName, lib.Version)
+			// Remove the previous version so that no stale files are left behind
+			libPath := util.LibPathFromName(lib.Name)
+			if err = os.RemoveAll(libPath); err == nil {
+				removed = true
+				_, err = unzip(job.Path, libPath)
+			}
+		}
 
-		resp, err := http.Get(lib.URL)
+		mutex.Lock()
+		defer mutex.Unlock()
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintf(os.Stderr, "Failed to install %s: %v\n", nameAndVersion, err)
+			failures[nameAndVersion] = err
+			// The previous version is still tracked unless it was removed
+			if removed {
+				delete(manifest.Libraries, lib.Name)
+			}
+			return
 		}
-		defer resp.Body.Close()
-		filename := path.Join(configuration.CLIDataDir, "downloads/libraries", lib.ArchiveFileName)
-		out, _ := os.Create(filename)
-		defer out.Close()
-		io.Copy(out, resp.Body)
+		manifest.Libraries[lib.Name] = libindex.ManifestEntry{
+			Version:     lib.Version,
+			Checksum:    lib.Checksum,
+			InstalledAt: changelog.SyncedAt,
+		}
+		change := libindex.Change{Name: lib.Name, OldVersion: previousVersions[lib.Name], NewVersion: lib.Version}
+		if change.OldVersion == "" {
+			changelog.Added = append(changelog.Added, change)
+		} else {
+			changelog.Updated = append(changelog.Updated, change)
+		}
+	})
 
-		_, err = unzip(filename, libPath)
+	// Remove or quarantine the libraries that are no longer in the index.
+	// Only libraries installed by installall are considered.
+	purge, _ := cmd.Flags().GetBool("purge")
+	for libName, entry := range manifest.Libraries {
+		if _, ok := libraries[libName]; ok {
+			continue
+		}
+		libPath := util.LibPathFromName(libName)
+		if purge {
+			fmt.Printf("Removing %s@%s\n", libName, entry.Version)
+			err = os.RemoveAll(libPath)
+		} else {
+			fmt.Printf("Quarantining %s@%s\n", libName, entry.Version)
+			dest := path.Join(util.QuarantineDirectory(), utils.SanitizeName(libName)+"@"+entry.Version)
+			os.MkdirAll(util.QuarantineDirectory(), os.ModePerm)
+			os.RemoveAll(dest)
+			err = os.Rename(libPath, dest)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintf(os.Stderr, "Failed to remove %s: %v\n", libName, err)
+			continue
+		}
+		delete(manifest.Libraries, libName)
+		changelog.Removed = append(changelog.Removed, libindex.Change{Name: libName, OldVersion: entry.Version})
+	}
+
+	// Save the manifest and the changelog
+	manifest.SyncedAt = changelog.SyncedAt
+	if err := manifest.Save(util.ManifestPath()); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not save library manifest: %v\n", err)
+		os.Exit(1)
+	}
+	// The changes are accumulated until testall --last-sync consumes them
+	pendingChanges, err := libindex.LoadChangelog(util.ChangelogPath())
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Warning: discarding unreadable library changelog: %v\n", err)
+	}
+	if err != nil || pendingChanges.Consumed {
+		pendingChanges = &libindex.Changelog{Since: changelog.SyncedAt}
+	}
+	pendingChanges.SyncedAt = changelog.SyncedAt
+	for _, c := range append(append(changelog.Added, changelog.Updated...), changelog.Removed...) {
+		pendingChanges.Add(c)
+	}
+	if err := pendingChanges.Save(util.ChangelogPath()); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not save library changelog: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Print the changelog
+	printChanges := func(title string, changes []libindex.Change) {
+		if len(changes) == 0 {
+			return
 		}
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+		fmt.Printf("\n%s (%d):\n", title, len(changes))
+		for _, c := range changes {
+			switch {
+			case c.OldVersion == "":
+				fmt.Printf("+ %s@%s\n", c.Name, c.NewVersion)
+			case c.NewVersion == "":
+				fmt.Printf("- %s@%s\n", c.Name, c.OldVersion)
+			default:
+				fmt.Printf("* %s: %s => %s\n", c.Name, c.OldVersion, c.NewVersion)
+			}
+		}
+	}
+	printChanges("Added", changelog.Added)
+	printChanges("Updated", changelog.Updated)
+	printChanges("Removed", changelog.Removed)
+
+	// Print a summary of failures
+	fmt.Printf("\nInstalled %d libraries, %d failed\n", len(jobs)-len(failures), len(failures))
+	printFailures(failures)
+}
+
+// addLibraryFilterFlags adds the options used to select libraries from the
+// index by their metadata.
+func addLibraryFilterFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringSlice("author", []string{}, "Only select libraries whose author matches the given glob pattern(s)")
+	cmd.PersistentFlags().StringSlice("maintainer", []string{}, "Only select libraries whose maintainer matches the given glob pattern(s)")
+	cmd.PersistentFlags().StringSlice("category", []string{}, "Only select libraries whose category matches the given glob pattern(s)")
+	cmd.PersistentFlags().StringSlice("types", []string{}, "Only select libraries whose types match the given glob pattern(s)")
+	cmd.PersistentFlags().StringSlice("architectures", []string{}, "Only select libraries claiming compatibility with the given architecture(s), including architectures=*")
+	cmd.PersistentFlags().Bool("with-deps", false, "Also select the dependencies of the selected libraries")
+}
+
+// selectLibraries applies the filters set with addLibraryFilterFlags.
+// Arguments are parsed as glob patterns on the library name, allowing
+// filters such as "Arduino_*".
+func selectLibraries(cmd *cobra.Command, cliArguments []string, libraries map[string]libindex.Library) map[string]libindex.Library {
+	filter := libindex.Filter{Names: cliArguments}
+	filter.Authors, _ = cmd.Flags().GetStringSlice("author")
+	filter.Maintainers, _ = cmd.Flags().GetStringSlice("maintainer")
+	filter.Categories, _ = cmd.Flags().GetStringSlice("category")
+	filter.Types, _ = cmd.Flags().GetStringSlice("types")
+	filter.Architectures, _ = cmd.Flags().GetStringSlice("architectures")
+	if filter.IsEmpty() {
+		return libraries
+	}
+
+	selected, err := filter.Apply(libraries)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid filter: %v\n", err)
+		os.Exit(1)
+	}
+	if withDeps, _ := cmd.Flags().GetBool("with-deps"); withDeps {
+		selected = libindex.WithDependencies(selected, libraries)
+	}
+	fmt.Printf("%d libraries match the filters\n", len(selected))
+	return selected
+}
+
+// printFailures lists the failed downloads and exits with an error code if
+// there are any.
+func printFailures(failures map[string]error) {
+	if len(failures) == 0 {
+		return
+	}
+	writeFailures(os.Stdout, failures)
+	os.Exit(1)
+}
+
+// adoptedEntry returns the manifest entry of a library installed before the
+// manifest existed. Its checksum is only recorded if the archive it was
+// installed from is still cached and matches the index; otherwise the entry
+// is left unverified, and the library is reinstalled by the next sync.
+func adoptedEntry(lib libindex.Library, archivePath string, syncedAt time.Time) libindex.ManifestEntry {
+	entry := libindex.ManifestEntry{Version: lib.Version, InstalledAt: syncedAt}
+	if download.Verify(archivePath, lib.Checksum, lib.Size) == nil {
+		entry.Checksum = lib.Checksum
+	}
+	return entry
+}
+
+// writeFailures writes the list of failures sorted by name.
+func writeFailures(w io.Writer, failures map[string]error) {
+	var failed []string
+	for name := range failures {
+		failed = append(failed, name)
+	}
+	sort.Strings(failed)
+	for _, name := range failed {
+		fmt.Fprintf(w, "- %s: %v\n", name, failures[name])
+	}
+}
+
+// libraryVersion returns the version declared in the library.properties file
+// of an installed library, or an empty string if it can't be read.
+func libraryVersion(libPath string) string {
+	properties, err := ini.Load(path.Join(libPath, "library.properties"))
+	if err != nil {
+		return ""
+	}
+	return properties.Section("").Key("version").String()
+}
+
+// libraryName returns the name declared in the library.properties file of a
+// library, or an empty string if it can't be read.
+func libraryName(libPath string) string {
+	properties, err := ini.Load(path.Join(libPath, "library.properties"))
+	if err != nil {
+		return ""
 	}
+	return properties.Section("").Key("name").String()
 }
 
 func unzip(src string, destination string) ([]string, error) {
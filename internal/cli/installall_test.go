@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alranel/arduino-testlib/internal/libindex"
+)
+
+func TestWriteFailures(t *testing.T) {
+	var b bytes.Buffer
+	writeFailures(&b, map[string]error{
+		"Foo@1.0.0": errors.New("checksum mismatch"),
+		"Bar@2.0.0": errors.New("GET http://example.com/Bar-2.0.0.zip: 404 Not Found"),
+	})
+	want := "- Bar@2.0.0: GET http://example.com/Bar-2.0.0.zip: 404 Not Found\n- Foo@1.0.0: checksum mismatch\n"
+	if b.String() != want {
+		t.Errorf("writeFailures wrote %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	writeFailures(&b, nil)
+	if b.Len() != 0 {
+		t.Errorf("writeFailures without failures wrote %q", b.String())
+	}
+}
+
+func TestAdoptedEntry(t *testing.T) {
+	archive := []byte("archive")
+	sum := sha256.Sum256(archive)
+	lib := libindex.Library{Name: "Foo", Version: "1.0.0", Checksum: "SHA-256:" + hex.EncodeToString(sum[:]), Size: int64(len(archive))}
+	archivePath := filepath.Join(t.TempDir(), "Foo-1.0.0.zip")
+	now := time.Now()
+
+	// Without the archive the installed library can't be verified
+	if e := adoptedEntry(lib, archivePath, now); e.Checksum != "" || e.Version != "1.0.0" {
+		t.Errorf("adoptedEntry without archive = %+v, want it unverified", e)
+	}
+
+	if err := os.WriteFile(archivePath, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if e := adoptedEntry(lib, archivePath, now); e.Checksum != "" {
+		t.Errorf("adoptedEntry with a mismatching archive = %+v, want it unverified", e)
+	}
+
+	if err := os.WriteFile(archivePath, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if e := adoptedEntry(lib, archivePath, now); e.Checksum != lib.Checksum || !e.InstalledAt.Equal(now) {
+		t.Errorf("adoptedEntry with a matching archive = %+v, want checksum %s", e, lib.Checksum)
+	}
+}
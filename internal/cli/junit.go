@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alranel/arduino-testlib/internal/junit"
+	"github.com/alranel/arduino-testlib/internal/store"
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/alranel/arduino-testlib/pkg/test"
+	"github.com/spf13/cobra"
+)
+
+// openJUnit creates the --junit file, returning nil if none was requested.
+func openJUnit(cmd *cobra.Command) *junit.Writer {
+	junitPath, _ := cmd.Flags().GetString("junit")
+	if junitPath == "" {
+		return nil
+	}
+	w, err := junit.Create(junitPath)
+	if err != nil {
+		util.Fatalf("Could not create JUnit file: %v\n", err)
+	}
+	return w
+}
+
+// writeJUnit adds the last result of each of the given FQBNs to the JUnit
+// file, including the ones skipped because already tested. Their logs are
+// read from the store, which is nil when results are not stored.
+func writeJUnit(w *junit.Writer, results store.Store, tr test.TestResults, fqbns []string) {
+	if results != nil {
+		if err := store.LoadLogs(results, &tr); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not read logs of %s: %v\n", tr.Name, err)
+		}
+	}
+	last := tr.LastTests()
+	var tests []test.TestResult
+	for _, fqbn := range fqbns {
+		if t, ok := last[fqbn]; ok {
+			tests = append(tests, t)
+		}
+	}
+	if err := w.Add(tr.Name, tests); err != nil {
+		util.Fatalf("Could not write JUnit file: %v\n", err)
+	}
+}
+
+// closeJUnit completes the JUnit file.
+func closeJUnit(w *junit.Writer) {
+	if err := w.Close(); err != nil {
+		util.Fatalf("Could not write JUnit file: %v\n", err)
+	}
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alranel/arduino-testlib/internal/store"
+	"github.com/alranel/arduino-testlib/pkg/test"
+	"github.com/spf13/cobra"
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge --datadir /path/to/dir SRC_DATADIR...",
+	Short: "Merge test results",
+	Long:  `This command merges the test results stored in one or more datadirs (for instance produced by testall --shard) into the datadir given with --datadir. When the same library version, FQBN and core version was tested in multiple datadirs, the result of the most recent run wins`,
+	Run:   runMerge,
+}
+
+func init() {
+	mergeCmd.PersistentFlags().String("source-store", "", "How test results are stored in the source datadirs (default: detected from the contents of each one); this allows converting a datadir to another store")
+	rootCmd.AddCommand(mergeCmd)
+}
+
+func runMerge(cmd *cobra.Command, cliArguments []string) {
+	// Check if a --datadir was supplied
+	datadirPath, _ := cmd.Flags().GetString("datadir")
+	if datadirPath == "" {
+		fmt.Fprintf(os.Stderr, "Missing required --datadir option\n")
+		os.Exit(1)
+	}
+	if len(cliArguments) == 0 {
+		fmt.Fprintf(os.Stderr, "Invalid arguments: please supply the datadirs to merge\n")
+		os.Exit(1)
+	}
+	sourceKind, _ := cmd.Flags().GetString("source-store")
+
+	defer lockDatadir(datadirPath)()
+	dst := openStore(cmd, datadirPath)
+	defer dst.Close()
+
+	merged := 0
+	for _, srcPath := range cliArguments {
+		if _, err := os.Stat(srcPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read datadir: %v\n", err)
+			os.Exit(1)
+		}
+		unlock := lockDatadirShared(srcPath)
+		kind := sourceKind
+		if kind == "" {
+			kind = store.Detect(srcPath)
+		}
+		src, err := store.Open(kind, srcPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open result store in %s: %v\n", srcPath, err)
+			os.Exit(1)
+		}
+		err = src.Walk(func(srcResults test.TestResults) error {
+			if srcResults.Name == "" {
+				return nil
+			}
+			// Logs are stored separately, so they need to be copied
+			if err := store.LoadLogs(src, &srcResults); err != nil {
+				return fmt.Errorf("could not read logs of %s: %v", srcResults.Name, err)
+			}
+			dstResults, err := test.MergeResults(getResults(dst, srcResults.Name), srcResults)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s in %s: %v\n", srcResults.Name, srcPath, err)
+				return nil
+			}
+			if err := dst.Put(dstResults); err != nil {
+				return fmt.Errorf("could not save test results: %v", err)
+			}
+			merged++
+			return nil
+		})
+		src.Close()
+		unlock()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Merge of %s failed: %v\n", srcPath, err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("Merged %d results into %s\n", merged, datadirPath)
+}
@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alranel/arduino-testlib/internal/cliclient"
+	"github.com/alranel/arduino-testlib/internal/configuration"
+	"github.com/alranel/arduino-testlib/internal/download"
+	"github.com/alranel/arduino-testlib/internal/libindex"
+	"github.com/alranel/arduino-testlib/internal/pkgindex"
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/spf13/cobra"
+)
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror --output /path/to/dir [LIB_GLOB...]",
+	Short: "Create a local mirror of the registry",
+	Long:  `This command downloads the library index, the package indexes and all the archives needed to install the libraries and the cores for the specified FQBNs into a directory that can be served over HTTP or used as a file:// mirror`,
+	Run:   runMirror,
+}
+
+func init() {
+	mirrorCmd.PersistentFlags().StringP("output", "o", "mirror", "The directory to write the mirror to.")
+	mirrorCmd.PersistentFlags().String("base-url", "", "The URL the mirror will be served from (default: file:// URL of the output directory)")
+	mirrorCmd.PersistentFlags().Bool("all-hosts", false, "Mirror the tools for all the host systems instead of the current one only")
+	mirrorCmd.PersistentFlags().IntP("threads", "j", 4, "How many parallel downloads to run")
+	mirrorCmd.PersistentFlags().Int("retries", 3, "How many times a failed download is retried")
+	addLibraryFilterFlags(mirrorCmd)
+	rootCmd.AddCommand(mirrorCmd)
+}
+
+func runMirror(cmd *cobra.Command, cliArguments []string) {
+	// Read configuration
+	if err := configuration.Initialize(cmd.Flags()); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	outputDir, _ := cmd.Flags().GetString("output")
+	baseURL, _ := cmd.Flags().GetString("base-url")
+	if baseURL == "" {
+		absPath, _ := filepath.Abs(outputDir)
+		baseURL = (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath)}).String()
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	os.MkdirAll(path.Join(outputDir, "libraries"), os.ModePerm)
+	os.MkdirAll(path.Join(outputDir, "packages"), os.ModePerm)
+
+	// Update the indexes; the library index is merged with the additional ones
+	cliclient.NewInstance()
+	dataDir := path.Join(configuration.CLIDataDir, "data")
+
+	var jobs []download.Job
+
+	// Select libraries
+	index, err := libindex.Load(path.Join(dataDir, "library_index.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read library index: %v\n", err)
+		os.Exit(1)
+	}
+	libraries := selectLibraries(cmd, cliArguments, index.Latest())
+	libNames := make([]string, 0, len(libraries))
+	for lib := range libraries {
+		libNames = append(libNames, lib)
+	}
+	sort.Strings(libNames)
+	mirroredLibraries := &libindex.Index{Libraries: []libindex.Library{}}
+	for _, name := range libNames {
+		lib := libraries[name]
+		jobs = append(jobs, download.Job{
+			URL:      lib.URL,
+			Path:     path.Join(outputDir, "libraries", lib.ArchiveFileName),
+			Checksum: lib.Checksum,
+			Size:     lib.Size,
+		})
+		lib.URL = baseURL + "/libraries/" + lib.ArchiveFileName
+		mirroredLibraries.Libraries = append(mirroredLibraries.Libraries, lib)
+	}
+
+	// Select the cores and their tools from the official and additional
+	// package indexes, which were downloaded into the data directory
+	indexFiles := []string{path.Join(dataDir, "package_index.json")}
+	if configuration.AdditionalURLs != "" {
+		for _, u := range strings.Split(configuration.AdditionalURLs, ",") {
+			if parsedURL, err := url.Parse(u); err == nil && parsedURL.Scheme == "file" {
+				indexFiles = append(indexFiles, parsedURL.Path)
+			} else if err == nil {
+				indexFiles = append(indexFiles, path.Join(dataDir, path.Base(parsedURL.Path)))
+			}
+		}
+	}
+	var packageIndexes []pkgindex.Index
+	for _, f := range indexFiles {
+		i, err := pkgindex.Load(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read package index: %v\n", err)
+			os.Exit(1)
+		}
+		packageIndexes = append(packageIndexes, i)
+	}
+	var cores []string
+	seenCores := make(map[string]bool)
+	for _, fqbn := range configuration.FQBNs {
+		if core := util.CoreFromFQBN(fqbn); !seenCores[core] {
+			seenCores[core] = true
+			cores = append(cores, core)
+		}
+	}
+	allHosts, _ := cmd.Flags().GetBool("all-hosts")
+	mirroredPackages, archives, err := pkgindex.Merge(packageIndexes...).Select(cores, allHosts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to select cores: %v\n", err)
+		os.Exit(1)
+	}
+	mirroredPackages.RewriteURLs(baseURL + "/packages")
+	for _, a := range archives {
+		jobs = append(jobs, download.Job{
+			URL:      a.URL,
+			Path:     path.Join(outputDir, "packages", a.ArchiveFileName),
+			Checksum: a.Checksum,
+			Size:     a.Size,
+		})
+	}
+
+	// Download all the archives
+	fmt.Printf("Mirroring %d libraries and %d core/tool archives\n", len(libraries), len(archives))
+	threads, _ := cmd.Flags().GetInt("threads")
+	retries, _ := cmd.Flags().GetInt("retries")
+	var mutex sync.Mutex
+	failures := make(map[string]error) // archive file name => error
+	download.All(jobs, threads, retries, func(job download.Job, cached bool, err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to download %s: %v\n", job.URL, err)
+			mutex.Lock()
+			failures[path.Base(job.Path)] = err
+			mutex.Unlock()
+		} else if !cached {
+			fmt.Printf("Downloaded %s\n", path.Base(job.Path))
+		}
+	})
+
+	// Write the indexes
+	if err := mirroredLibraries.Save(path.Join(outputDir, "library_index.json")); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write library index: %v\n", err)
+		os.Exit(1)
+	}
+	if err := mirroredPackages.Save(path.Join(outputDir, "package_index.json")); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write package index: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\nMirror written to %s (base URL: %s), %d downloads failed\n", outputDir, baseURL, len(failures))
+	printFailures(failures)
+}
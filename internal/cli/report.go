@@ -3,7 +3,9 @@ package cli
 import (
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/alranel/arduino-testlib/internal/compare"
 	"github.com/alranel/arduino-testlib/internal/configuration"
 	"github.com/alranel/arduino-testlib/internal/report"
 	"github.com/spf13/cobra"
@@ -17,7 +19,10 @@ var reportCmd = &cobra.Command{
 }
 
 func init() {
-	reportCmd.PersistentFlags().StringP("output", "o", "report", "The directory to write the HTML report to.")
+	reportCmd.PersistentFlags().StringP("output", "o", "report", "The directory to write the report to.")
+	reportCmd.Flags().String("format", "html", "The format of the report: "+strings.Join(report.Formats, ", "))
+	reportCmd.Flags().String("baseline", "", "With the markdown format, list the pairs newly failing compared to a baseline (a datadir or a results JSON file)")
+	reportCmd.Flags().Int("max-size", report.DefaultMarkdownSize, "With the markdown format, the maximum size of the summary in bytes (0 for no limit)")
 	rootCmd.AddCommand(reportCmd)
 }
 
@@ -36,6 +41,35 @@ func runReport(cmd *cobra.Command, cliArguments []string) {
 	}
 
 	outputDir, _ := cmd.Flags().GetString("output")
+	format, _ := cmd.Flags().GetString("format")
+	if !validFormat(format) {
+		fmt.Fprintf(os.Stderr, "Invalid format: %s\n", format)
+		os.Exit(1)
+	}
+
+	defer lockDatadirShared(datadirPath)()
+	results := openStore(cmd, datadirPath)
+	defer results.Close()
+
+	opts := report.Options{Format: format}
+	opts.MaxSize, _ = cmd.Flags().GetInt("max-size")
+	if baselinePath, _ := cmd.Flags().GetString("baseline"); baselinePath != "" {
+		baseline, err := compare.OpenBaseline(baselinePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open baseline: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Baseline, opts.BaselinePath = baseline, baselinePath
+	}
+
+	report.Generate(results, outputDir, opts)
+}
 
-	report.Generate(datadirPath, outputDir)
+func validFormat(format string) bool {
+	for _, f := range report.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
 }
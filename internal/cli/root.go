@@ -19,8 +19,11 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.PersistentFlags().String("datadir", "", "The directory where test results are stored.")
+	rootCmd.PersistentFlags().String("store", "json", "How test results are stored in the datadir: json (one file per library) or sqlite.")
 	rootCmd.PersistentFlags().String("cli-datadir", "", "A custom directory for arduino-cli data.")
 	rootCmd.PersistentFlags().String("additional-urls", "", "Comma-separated list of additional URLs for the Boards Manager.")
+	rootCmd.PersistentFlags().String("additional-library-indexes", "", "Comma-separated list of additional library indexes (URLs or local files), taking precedence over the official one.")
+	rootCmd.PersistentFlags().String("mirror", "", "The base URL (http:// or file://) of a mirror created with the mirror command, to be used instead of the official indexes.")
 	rootCmd.PersistentFlags().StringSlice("fqbn", []string{}, "The FQBN(s) to compile the library against.")
 }
 
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alranel/arduino-testlib/internal/configuration"
+	"github.com/alranel/arduino-testlib/internal/libindex"
+	"github.com/alranel/arduino-testlib/internal/store"
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/alranel/arduino-testlib/pkg/test"
+	"github.com/spf13/cobra"
+	"gopkg.in/ini.v1"
+)
+
+// addSelectorFlags registers the flags selecting the library/board pairs to
+// re-test.
+func addSelectorFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().Bool("rerun-failed", false, "Only test the library/board pairs whose last result was FAIL")
+	cmd.PersistentFlags().String("changed-since", "", "Only test the libraries installed or updated by installall after a date (YYYY-MM-DD) or a run (its RFC 3339 start time)")
+	cmd.PersistentFlags().StringSlice("claims", []string{}, "Only test the libraries declaring compatibility with an architecture, including the ones declaring *; can be used multiple times")
+	cmd.PersistentFlags().StringSlice("status", []string{}, "Only test the library/board pairs with a compatibility status (PASS_CLAIM, PASS_NOCLAIM, FAIL_CLAIM, FAIL_NOCLAIM); can be used multiple times")
+}
+
+// selectPairs applies the selectors to the library/board pairs, returning
+// the selected libraries and the FQBNs to test for each of them. A pair is
+// selected when all the selectors match. The selection is nil when no
+// selectors were used.
+//
+// --rerun-failed and --status select pairs by their last result, which would
+// otherwise be skipped as already tested: see selectorsForce. The other
+// selectors only restrict the pairs to test.
+func selectPairs(cmd *cobra.Command, libNames []string, results store.Store) ([]string, map[string][]string, error) {
+	rerunFailed, _ := cmd.Flags().GetBool("rerun-failed")
+	changedSinceArg, _ := cmd.Flags().GetString("changed-since")
+	claims, _ := cmd.Flags().GetStringSlice("claims")
+	statusArgs, _ := cmd.Flags().GetStringSlice("status")
+	if !rerunFailed && changedSinceArg == "" && len(claims) == 0 && len(statusArgs) == 0 {
+		return libNames, nil, nil
+	}
+
+	var changedSince time.Time
+	var manifest *libindex.Manifest
+	if changedSinceArg != "" {
+		var err error
+		if changedSince, err = time.Parse(time.RFC3339, changedSinceArg); err != nil {
+			if changedSince, err = time.ParseInLocation("2006-01-02", changedSinceArg, time.Local); err != nil {
+				return nil, nil, fmt.Errorf("--changed-since: expected a date (YYYY-MM-DD) or a run: %s", changedSinceArg)
+			}
+		}
+		if manifest, err = libindex.LoadManifest(util.ManifestPath()); err != nil {
+			return nil, nil, fmt.Errorf("--changed-since: %v", err)
+		}
+	}
+	statuses := make(map[test.CompatibilityStatus]bool)
+	for _, s := range statusArgs {
+		status := test.CompatibilityStatus(strings.ToUpper(s))
+		switch status {
+		case test.PASS_CLAIM, test.PASS_NOCLAIM, test.FAIL_CLAIM, test.FAIL_NOCLAIM:
+			statuses[status] = true
+		default:
+			return nil, nil, fmt.Errorf("--status: unknown compatibility status: %s", s)
+		}
+	}
+
+	var selected []string
+	selection := make(map[string][]string)
+	pairs := 0
+	for _, lib := range libNames {
+		libPath := util.LibPathFromName(lib)
+		if changedSinceArg != "" && !installedAt(manifest, lib, libPath).After(changedSince) {
+			continue
+		}
+		if len(claims) > 0 && !claimsArchitecture(libPath, claims) {
+			continue
+		}
+
+		// The other selectors look at the last result of each pair
+		var last map[string]test.TestResult
+		if rerunFailed || len(statuses) > 0 {
+			tr, err := results.Get(lib)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+			last = tr.LastTests()
+		}
+		var fqbns []string
+		for _, fqbn := range configuration.FQBNs {
+			if last != nil {
+				t, ok := last[fqbn]
+				if !ok || (rerunFailed && t.Result != test.FAIL) || (len(statuses) > 0 && !statuses[t.Status()]) {
+					continue
+				}
+			}
+			fqbns = append(fqbns, fqbn)
+		}
+		if len(fqbns) > 0 {
+			selected = append(selected, lib)
+			selection[lib] = fqbns
+			pairs += len(fqbns)
+		}
+	}
+	fmt.Printf("Selected %d library/board pairs in %d libraries\n", pairs, len(selected))
+	return selected, selection, nil
+}
+
+// selectorsForce checks whether the selected pairs must be re-tested even if
+// they were already tested with the same library and core versions.
+func selectorsForce(cmd *cobra.Command) bool {
+	rerunFailed, _ := cmd.Flags().GetBool("rerun-failed")
+	statusArgs, _ := cmd.Flags().GetStringSlice("status")
+	return rerunFailed || len(statusArgs) > 0
+}
+
+// installedAt returns when the library was installed or updated, as recorded
+// in the installall manifest. Libraries installed otherwise fall back to the
+// modification time of their library.properties, which is the time they
+// were extracted.
+func installedAt(manifest *libindex.Manifest, lib string, libPath string) time.Time {
+	if entry, ok := manifest.Libraries[lib]; ok {
+		return entry.InstalledAt
+	}
+	info, err := os.Stat(path.Join(libPath, "library.properties"))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// claimsArchitecture checks whether the installed library declares
+// compatibility with any of the given architectures.
+func claimsArchitecture(libPath string, architectures []string) bool {
+	properties, err := ini.Load(path.Join(libPath, "library.properties"))
+	if err != nil {
+		return false
+	}
+	for _, arch := range strings.Split(properties.Section("").Key("architectures").String(), ",") {
+		arch = strings.TrimSpace(strings.ToLower(arch))
+		for _, a := range architectures {
+			if arch == "*" || arch == strings.ToLower(a) {
+				return true
+			}
+		}
+	}
+	return false
+}
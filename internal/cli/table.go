@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+const (
+	ansiRed   = "\033[31m"
+	ansiGreen = "\033[32m"
+	ansiBold  = "\033[1m"
+	ansiDim   = "\033[2m"
+	ansiReset = "\033[0m"
+)
+
+// tableCell is a cell of a table, with an optional ANSI colour.
+type tableCell struct {
+	text  string
+	color string
+}
+
+// printTable prints the results of a library as a board by inclusion and
+// examples grid, followed by the first error of each failed compilation.
+// Results not coming from the given run are marked as cached.
+func printTable(w io.Writer, tr test.TestResults, fqbns []string, run string, color bool) {
+	paint := func(s string, c string) string {
+		if !color || c == "" || s == "" {
+			return s
+		}
+		return c + s + ansiReset
+	}
+	resultColor := func(pass bool) string {
+		if pass {
+			return ansiGreen
+		}
+		return ansiRed
+	}
+
+	last := tr.LastTests()
+	rows := [][]tableCell{{{text: "BOARD"}, {text: "STATUS"}, {text: "INCLUSION"}, {text: "EXAMPLES"}, {text: ""}}}
+	var errors []string
+	var version string
+	for _, fqbn := range fqbns {
+		t, ok := last[fqbn]
+		if !ok {
+			rows = append(rows, []tableCell{{text: fqbn}, {text: "-"}, {text: "-"}, {text: "-"}, {text: ""}})
+			continue
+		}
+		version = t.Version
+		status := t.Status()
+		passed := 0
+		for _, e := range t.Examples {
+			if e.Result == test.PASS {
+				passed++
+			}
+		}
+		// Failures on boards the library doesn't claim are expected
+		statusColor := resultColor(t.Result == test.PASS)
+		if status == test.FAIL_NOCLAIM {
+			statusColor = ""
+		}
+		cached := ""
+		if t.Run != run {
+			cached = "cached"
+		}
+		rows = append(rows, []tableCell{
+			{text: fqbn},
+			{text: string(status), color: statusColor},
+			{text: string(t.Result), color: resultColor(t.Result == test.PASS)},
+			{text: fmt.Sprintf("%d/%d", passed, len(t.Examples)), color: resultColor(passed == len(t.Examples))},
+			{text: cached, color: ansiDim},
+		})
+
+		if t.Result == test.FAIL {
+			errors = append(errors, fmt.Sprintf("%s inclusion: %s", fqbn, test.FirstError(t.Log)))
+		}
+		for _, e := range t.Examples {
+			if e.Result == test.FAIL {
+				errors = append(errors, fmt.Sprintf("%s %s: %s", fqbn, e.Name, test.FirstError(e.Log)))
+			}
+		}
+	}
+
+	// Cells are padded by hand, as the colour codes would confuse tabwriter
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell.text) > widths[i] {
+				widths[i] = len(cell.text)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%s\n\n", paint(strings.TrimSuffix(tr.Name+" "+version, " "), ansiBold))
+	for _, row := range rows {
+		var line string
+		for i, cell := range row {
+			line += paint(cell.text, cell.color) + strings.Repeat(" ", widths[i]-len(cell.text)+2)
+		}
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+	if len(errors) > 0 {
+		fmt.Fprintln(w)
+		for _, e := range errors {
+			fmt.Fprintf(w, "%s %s\n", paint("✗", ansiRed), e)
+		}
+	}
+}
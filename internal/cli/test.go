@@ -4,9 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/alranel/arduino-testlib/internal/cliclient"
+	"github.com/alranel/arduino-testlib/internal/compare"
 	"github.com/alranel/arduino-testlib/internal/configuration"
+	"github.com/alranel/arduino-testlib/internal/report"
+	"github.com/alranel/arduino-testlib/internal/sarif"
+	"github.com/alranel/arduino-testlib/internal/store"
+	"github.com/alranel/arduino-testlib/internal/util"
 	"github.com/alranel/arduino-testlib/pkg/test"
 	"github.com/spf13/cobra"
 )
@@ -20,6 +28,11 @@ var testCmd = &cobra.Command{
 
 func init() {
 	testCmd.PersistentFlags().BoolP("force", "f", false, "Re-test all library-core combinations even if already seen")
+	testCmd.PersistentFlags().String("output", "", "Output format: table, json or jsonl (one event per compilation); table is the default on a terminal, json otherwise")
+	testCmd.PersistentFlags().String("junit", "", "Also write the results to a JUnit XML file")
+	testCmd.PersistentFlags().String("summary-md", "", "Also append a Markdown summary of the results to a file, such as $GITHUB_STEP_SUMMARY")
+	testCmd.PersistentFlags().String("sarif", "", "Also write the compiler errors and warnings located in the library to a SARIF file")
+	addGateFlags(testCmd)
 	rootCmd.AddCommand(testCmd)
 }
 
@@ -33,13 +46,182 @@ func runTest(cmd *cobra.Command, cliArguments []string) {
 		os.Exit(1)
 	}
 
+	output, _ := cmd.Flags().GetString("output")
+	if output == "" {
+		output = "json"
+		if util.IsTerminal(os.Stdout) {
+			output = "table"
+		}
+	}
+	if output != "table" && output != "json" && output != "jsonl" {
+		fmt.Fprintf(os.Stderr, "Invalid output format: %s\n", output)
+		os.Exit(1)
+	}
+	gate := openGate(cmd)
+	junitWriter := openJUnit(cmd)
+
+	// Like the test progress, the download progress goes to stderr
+	cliclient.Progress = os.Stderr
 	instance := cliclient.NewInstance()
 	instance.InstallCores()
 
+	// When a --datadir is supplied, previous results are read from it and
+	// the new ones are stored into it
 	var tr test.TestResults
+	var results store.Store
+	if datadirPath, _ := cmd.Flags().GetString("datadir"); datadirPath != "" {
+		defer lockDatadir(datadirPath)()
+		results = openStore(cmd, datadirPath)
+		defer results.Close()
+		if name := libraryName(cliArguments[0]); name != "" {
+			tr = getResults(results, name)
+		}
+	}
+
+	var base test.TestResults
+	if gate != nil {
+		base = gate.Base(libraryName(cliArguments[0]))
+	}
+
 	force, _ := cmd.Flags().GetBool("force")
-	tr = test.TestLib(cliArguments[0], tr, force, instance)
+	opts := test.Options{
+		Force:              force,
+		InstalledLibraries: instance.GetInstalledLibraryVersions(),
+		Run:                time.Now().UTC().Format(time.RFC3339),
+		// Progress messages go to stderr, keeping the output parseable
+		Progress: os.Stderr,
+	}
+	// The warnings are only needed by the SARIF file, and make the logs
+	// much larger
+	if sarifPath, _ := cmd.Flags().GetString("sarif"); sarifPath != "" {
+		opts.Warnings = "all"
+	}
+	if output == "jsonl" {
+		enc := json.NewEncoder(os.Stdout)
+		opts.OnCompile = func(e test.CompileEvent) {
+			enc.Encode(e)
+		}
+	}
+	tr = test.TestLib(cliArguments[0], tr, opts, instance)
+
+	if results != nil && tr.Name != "" {
+		if err := results.Put(tr); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not save test results: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if junitWriter != nil {
+		writeJUnit(junitWriter, results, tr, configuration.FQBNs)
+		closeJUnit(junitWriter)
+	}
+
+	// Cached results reference their stored logs, which are needed to
+	// extract the errors
+	sarifPath, _ := cmd.Flags().GetString("sarif")
+	summaryPath, _ := cmd.Flags().GetString("summary-md")
+	if results != nil && (output == "table" || output == "json" || sarifPath != "" || summaryPath != "") {
+		if err := store.LoadLogs(results, &tr); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not load logs: %v\n", err)
+		}
+	}
+
+	if sarifPath != "" {
+		libPath, _ := filepath.Abs(cliArguments[0])
+		var tests []test.TestResult
+		last := tr.LastTests()
+		for _, fqbn := range configuration.FQBNs {
+			if t, ok := last[fqbn]; ok {
+				tests = append(tests, t)
+			}
+		}
+		if err := sarif.Write(sarifPath, libPath, tests); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write SARIF file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if summaryPath != "" {
+		writeSummary(summaryPath, tr, gate, base)
+	}
+
+	switch output {
+	case "table":
+		printTable(os.Stdout, tr, configuration.FQBNs, opts.Run, util.UseColor(os.Stdout))
+	case "json":
+		b, _ := json.MarshalIndent(jsonOutput(tr), "", "  ")
+		fmt.Printf("%s\n", b)
+	}
+
+	// The summary goes to stderr, keeping the JSON output parseable
+	if gate != nil {
+		gate.Check(base, tr, configuration.FQBNs)
+		gate.Finish(os.Stderr)
+	}
+}
+
+// resultsOutput is the json output of the test command: the results of the
+// library with the logs and the metadata in each test, so that it doesn't
+// depend on how the results are stored.
+type resultsOutput struct {
+	Name    string              `json:"name"`
+	Tests   []testOutput        `json:"tests"`
+	History []test.HistoryEntry `json:"history,omitempty"`
+}
+
+type testOutput struct {
+	test.TestResult
+	test.Metadata
+}
 
-	b, _ := json.MarshalIndent(tr, "", "  ")
-	fmt.Printf("%s\n", b)
+// jsonOutput converts results whose logs were loaded to the json output.
+func jsonOutput(tr test.TestResults) resultsOutput {
+	out := resultsOutput{Name: tr.Name, History: tr.History}
+	for _, t := range tr.Tests {
+		t.LogHash = ""
+		examples := make([]test.ExampleResult, len(t.Examples))
+		for i, e := range t.Examples {
+			e.LogHash = ""
+			examples[i] = e
+		}
+		t.Examples = examples
+		out.Tests = append(out.Tests, testOutput{t, t.Metadata})
+	}
+	return out
+}
+
+// writeSummary appends a Markdown summary of the last results of the
+// configured FQBNs to a file, comparing them with the baseline if any.
+func writeSummary(summaryPath string, tr test.TestResults, gate *gate, base test.TestResults) {
+	last := tr.LastTests()
+	lib := test.TestResults{Name: tr.Name}
+	var version string
+	for _, fqbn := range configuration.FQBNs {
+		if t, ok := last[fqbn]; ok {
+			lib.Tests = append(lib.Tests, t)
+			version = t.Version
+		}
+	}
+	digest := report.Digest{
+		Title:     strings.TrimSpace(tr.Name + " " + version),
+		Libraries: []test.TestResults{lib},
+		FQBNs:     configuration.FQBNs,
+		MaxSize:   report.DefaultMarkdownSize,
+	}
+	if gate != nil {
+		digest.Baseline = gate.path
+		digest.Changes = compare.Compare(base, tr, configuration.FQBNs)
+	}
+
+	f, err := os.OpenFile(summaryPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = f.WriteString(digest.Markdown())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write Markdown summary: %v\n", err)
+		os.Exit(1)
+	}
 }
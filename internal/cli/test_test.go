@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+func TestJSONOutput(t *testing.T) {
+	m := test.Metadata{Author: "Jane Doe", Layout: test.LayoutSrc}
+	tr := test.TestResults{Name: "Foo", Tests: []test.TestResult{
+		{Version: "1.0.0", FQBN: "arduino:avr:uno", Result: test.FAIL, Log: "error\n", LogHash: "abc", Metadata: m,
+			Examples: []test.ExampleResult{{Name: "Blink", Result: test.FAIL, Log: "error\n", LogHash: "abc"}}},
+	}}
+	b, err := json.Marshal(jsonOutput(tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+	for _, s := range []string{`"log_hash"`, `"versions"`} {
+		if strings.Contains(out, s) {
+			t.Errorf("output contains %s: %s", s, out)
+		}
+	}
+	for _, s := range []string{`"log":"error\n"`, `"author":"Jane Doe"`, `"layout":"src"`, `"fqbn":"arduino:avr:uno"`} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output lacks %s: %s", s, out)
+		}
+	}
+	if tr.Tests[0].LogHash != "abc" || tr.Tests[0].Examples[0].LogHash != "abc" {
+		t.Errorf("jsonOutput modified the results")
+	}
+}
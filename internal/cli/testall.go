@@ -1,25 +1,30 @@
 package cli
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
 	"os"
-	"path"
+	"os/signal"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/alranel/arduino-testlib/internal/cliclient"
+	"github.com/alranel/arduino-testlib/internal/compare"
 	"github.com/alranel/arduino-testlib/internal/configuration"
+	"github.com/alranel/arduino-testlib/internal/events"
+	"github.com/alranel/arduino-testlib/internal/journal"
+	"github.com/alranel/arduino-testlib/internal/libindex"
+	"github.com/alranel/arduino-testlib/internal/tui"
+	"github.com/alranel/arduino-testlib/internal/util"
 	"github.com/alranel/arduino-testlib/pkg/test"
-	"github.com/arduino/arduino-cli/arduino/utils"
 	"github.com/gobwas/glob"
 	"github.com/spf13/cobra"
-	"golang.org/x/sync/semaphore"
 )
 
 var testallCmd = &cobra.Command{
@@ -32,6 +37,16 @@ var testallCmd = &cobra.Command{
 func init() {
 	testallCmd.PersistentFlags().IntP("threads", "j", 1, "How many parallel jobs to run")
 	testallCmd.PersistentFlags().BoolP("force", "f", false, "Re-test all library-core combinations even if already seen")
+	testallCmd.PersistentFlags().String("shard", "", "Only test the i-th of n shards of the libraries, in the i/n form (eg. 1/4)")
+	testallCmd.PersistentFlags().String("shard-balance", "name", "How to partition libraries into shards: by name, or by historical test duration")
+	testallCmd.PersistentFlags().String("shard-durations", "", "The datadir or JSON results file whose test durations balance the shards with --shard-balance duration; all the shards must use the same one")
+	testallCmd.PersistentFlags().Bool("resume", false, "Resume the last interrupted run")
+	testallCmd.PersistentFlags().Bool("last-sync", false, "Only test the libraries added or updated by the last installall run")
+	addSelectorFlags(testallCmd)
+	testallCmd.PersistentFlags().Bool("tui", false, "Show a full-screen dashboard of the run instead of the plain output, when on a terminal")
+	testallCmd.PersistentFlags().String("junit", "", "Also write the results of the libraries tested to a JUnit XML file")
+	testallCmd.PersistentFlags().String("events", "", "Write progress events as JSON lines to a file, or to a socket given as unix:/path or tcp:host:port")
+	addGateFlags(testallCmd)
 	rootCmd.AddCommand(testallCmd)
 }
 
@@ -49,56 +64,164 @@ func runTestall(cmd *cobra.Command, cliArguments []string) {
 		os.Exit(1)
 	}
 
+	// Lock the datadir, preventing concurrent runs from overwriting each
+	// other's results
+	defer lockDatadir(datadirPath)()
+	results := openStore(cmd, datadirPath)
+	defer results.Close()
+	gate := openGate(cmd)
+	junitWriter := openJUnit(cmd)
+
+	var stream *events.Stream
+	if target, _ := cmd.Flags().GetString("events"); target != "" {
+		stream = &events.Stream{}
+		if err := stream.Open(target); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open events stream: %v\n", err)
+			os.Exit(1)
+		}
+		defer stream.Close()
+	}
+
 	instance := cliclient.NewInstance()
 
 	// Install all the required cores
 	instance.InstallCores()
 
-	// Define the list of the libraries to test. If no libraries were supplied as
-	// arguments, the entire list from the Library Registry will be used.
-	libraries := make(map[string]string) // unsanitized name => version
-	{
-		var libs []string
-		if len(cliArguments) == 0 {
-			libs = instance.GetInstalledLibraries()
-		} else {
-			// Parse arguments as glob patterns, allowing filters such as "Arduino_*"
-			for _, arg := range cliArguments {
-				g := glob.MustCompile(arg)
-				for _, lib := range instance.GetInstalledLibraries() {
-					if g.Match(lib) {
-						libs = append(libs, lib)
-					}
+	// Find the versions of the installed cores, which are part of the run
+	// parameters recorded in the journal
+	coreVersions := make(map[string]string)
+	for _, fqbn := range configuration.FQBNs {
+		core := util.CoreFromFQBN(fqbn)
+		coreVersion, err := instance.GetInstalledCoreVersion(core)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get core version for %s: %v\n", core, err)
+			os.Exit(1)
+		}
+		coreVersions[core] = coreVersion
+	}
+
+	// Plan the run, or resume an interrupted one
+	force, _ := cmd.Flags().GetBool("force")
+	var libNames []string
+	var selection map[string][]string // lib => FQBNs
+	var runJournal *journal.Journal
+	var changelogSyncedAt time.Time // of the changes tested with --last-sync
+	if resume, _ := cmd.Flags().GetBool("resume"); resume {
+		var err error
+		runJournal, err = journal.Load(datadirPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "No interrupted run to resume: %v\n", err)
+			os.Exit(1)
+		}
+		if err := runJournal.Matches(configuration.FQBNs, coreVersions); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot resume the interrupted run: %v\n", err)
+			os.Exit(1)
+		}
+		force = runJournal.Force
+		selection = runJournal.Selection
+		libNames = runJournal.Pending()
+		fmt.Printf("Resuming run started on %s: %d/%d libraries left\n", runJournal.StartedAt.Format(time.RFC850), len(libNames), len(runJournal.Jobs))
+	} else {
+		if lastSync, _ := cmd.Flags().GetBool("last-sync"); lastSync {
+			if changelog, err := libindex.LoadChangelog(util.ChangelogPath()); err == nil {
+				changelogSyncedAt = changelog.SyncedAt
+			}
+		}
+		libNames = testallLibraries(cmd, cliArguments, instance)
+		var err error
+		libNames, selection, err = selectPairs(cmd, libNames, results)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid selector: %v\n", err)
+			os.Exit(1)
+		}
+		if shardArg, _ := cmd.Flags().GetString("shard"); shardArg != "" {
+			balance, _ := cmd.Flags().GetString("shard-balance")
+			var durations compare.Baseline
+			if durationsPath, _ := cmd.Flags().GetString("shard-durations"); durationsPath != "" {
+				if durations, err = compare.OpenBaseline(durationsPath); err != nil {
+					fmt.Fprintf(os.Stderr, "Could not read shard durations: %v\n", err)
+					os.Exit(1)
+				}
+				if c, ok := durations.(io.Closer); ok {
+					defer c.Close()
 				}
 			}
+			libNames, err = shardLibraries(libNames, shardArg, balance, durations)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid shard: %v\n", err)
+				os.Exit(1)
+			}
 		}
-		for _, lib := range libs {
-			t := strings.SplitN(lib, "@", 2)
-			version := ""
-			if len(t) > 1 {
-				version = t[1]
+		if selectorsForce(cmd) {
+			force = true
+		}
+		runJournal = journal.New(datadirPath, configuration.FQBNs, coreVersions, force, libNames)
+		runJournal.Selection = selection
+		if err := runJournal.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not save run journal: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	run := runJournal.StartedAt.UTC().Format(time.RFC3339)
+
+	// The versions of the installed libraries are used to detect the tests
+	// invalidated by changes in the libraries they use
+	installedLibraries := instance.GetInstalledLibraryVersions()
+
+	// Create a new CLI instance for each worker, one at a time since their
+	// initialization isn't concurrency safe. This is done before starting
+	// the dashboard, which their progress output would overwrite
+	noOfWorkers, _ := cmd.Flags().GetInt("threads")
+	workerInstances := make([]*cliclient.CliInstance, noOfWorkers)
+	for i := range workerInstances {
+		fmt.Printf("[#%d] Initializing CLI\n", i)
+		workerInstances[i] = cliclient.NewInstance()
+		fmt.Printf("[#%d] Done initializing CLI\n", i)
+	}
+
+	// The dashboard replaces the plain output, falling back to it when not
+	// on a terminal
+	var out io.Writer = os.Stdout
+	var dashboard *tui.Dashboard
+	if useTUI, _ := cmd.Flags().GetBool("tui"); useTUI {
+		dashboard = tui.New(libNames, configuration.FQBNs, noOfWorkers, libraryDurations(libNames, results))
+		if err := dashboard.Start(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot show the dashboard (%v), using the plain output\n", err)
+			dashboard = nil
+		} else {
+			// Restore the terminal on the exits from the packages testing
+			// the libraries too
+			util.AtExit(dashboard.Stop)
+			out = ioutil.Discard
+			cliclient.Progress = ioutil.Discard
+			if stream == nil {
+				stream = &events.Stream{}
 			}
-			libraries[t[0]] = version
+			stream.Handle(dashboard.Handle)
 		}
 	}
 
 	var jobs = make(chan string)
-	ctx := context.TODO()
-	sem := semaphore.NewWeighted(1)
-	var done int32
+	var done, passed, failed int32
 	t0 := time.Now()
+	stream.SetRun(run)
+	stream.Emit(events.Event{Type: events.RunStarted, FQBNs: configuration.FQBNs, Total: len(libNames)})
+	runFinished := func(interrupted bool) events.Event {
+		return events.Event{
+			Type:        events.RunFinished,
+			Duration:    time.Since(t0).Seconds(),
+			Passed:      int(atomic.LoadInt32(&passed)),
+			Failed:      int(atomic.LoadInt32(&failed)),
+			Done:        int(atomic.LoadInt32(&done)),
+			Total:       len(libNames),
+			Interrupted: interrupted,
+		}
+	}
 
-	force, _ := cmd.Flags().GetBool("force")
 	worker := func(wg *sync.WaitGroup, workerId int) {
-		// Create a new CLI instance for each worker, preventing concurrency
-		if err := sem.Acquire(ctx, 1); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to acquire semaphore: %v", err)
-			os.Exit(1)
-		}
-		fmt.Printf("[#%d] Initializing CLI\n", workerId)
-		instance := cliclient.NewInstance()
-		fmt.Printf("[#%d] Done initializing CLI\n", workerId)
-		sem.Release(1)
+		instance := workerInstances[workerId]
+		stream.Emit(events.Event{Type: events.WorkerReady}.ForWorker(workerId))
 
 		for {
 			lib, more := <-jobs
@@ -107,34 +230,70 @@ func runTestall(cmd *cobra.Command, cliArguments []string) {
 				return
 			}
 
-			var tr test.TestResults
+			// The version is also known when all the pairs are skipped
+			version := libraryVersion(util.LibPathFromName(lib))
+			stream.Emit(events.Event{Type: events.LibraryStarted, Library: lib, Version: version}.ForWorker(workerId))
+			t1 := time.Now()
 
 			// Read previous test results from datadir
-			testResultsFile := path.Join(datadirPath, utils.SanitizeName(lib)+".json")
-			test.ReadResultsFile(testResultsFile, &tr)
+			tr := getResults(results, lib)
 
-			tr = test.TestLibByName(lib, tr, force, instance)
+			opts := test.Options{Force: force, InstalledLibraries: installedLibraries, Run: run, Progress: out}
+			if selection != nil {
+				opts.FQBNs = selection[lib]
+			}
+			var base test.TestResults
+			if gate != nil {
+				base = gate.Base(lib)
+			}
+			libFinished := events.Event{Type: events.LibraryFinished, Library: lib, Version: version}
+			opts.OnCompileStart = func(e test.CompileEvent) {
+				stream.Emit(events.Compile(e).ForWorker(workerId))
+			}
+			opts.OnCompile = func(e test.CompileEvent) {
+				libFinished.Version = e.Version
+				if e.Result == test.PASS {
+					libFinished.Passed++
+					atomic.AddInt32(&passed, 1)
+				} else {
+					libFinished.Failed++
+					atomic.AddInt32(&failed, 1)
+				}
+				stream.Emit(events.Compile(e).ForWorker(workerId))
+			}
+			tr = test.TestLibByName(lib, tr, opts, instance)
+			fqbns := opts.FQBNs
+			if fqbns == nil {
+				fqbns = configuration.FQBNs
+			}
+			if gate != nil {
+				gate.Check(base, tr, fqbns)
+			}
+
+			if junitWriter != nil {
+				writeJUnit(junitWriter, results, tr, fqbns)
+			}
 
 			// Write test results to datadir
-			{
-				jsonData, _ := json.MarshalIndent(tr, "", "  ")
-				err := ioutil.WriteFile(testResultsFile, jsonData, 0644)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Could not save test results: %v\n", err)
-					os.Exit(1)
-				}
+			if err := results.Put(tr); err != nil {
+				util.Fatalf("Could not save test results: %v\n", err)
+			}
+			if err := runJournal.MarkDone(lib); err != nil {
+				util.Fatalf("Could not save run journal: %v\n", err)
 			}
 
 			// Increment counter and print stats
-			atomic.AddInt32(&done, 1)
-			eta := int(time.Now().Sub(t0).Seconds() / float64(done) * float64(len(libraries)-int(done)))
-			fmt.Printf("[#%d] done %d/%d libs (ETA: %ds)\n", workerId, done, len(libraries), eta)
+			libFinished.Done = int(atomic.AddInt32(&done, 1))
+			libFinished.Total = len(libNames)
+			libFinished.Duration = time.Since(t1).Seconds()
+			stream.Emit(libFinished.ForWorker(workerId))
+			eta := int(time.Now().Sub(t0).Seconds() / float64(done) * float64(len(libNames)-int(done)))
+			fmt.Fprintf(out, "[#%d] done %d/%d libs (ETA: %ds)\n", workerId, done, len(libNames), eta)
 		}
 	}
 
-	noOfWorkers, _ := cmd.Flags().GetInt("threads")
 	if noOfWorkers > 1 {
-		fmt.Printf("Warning: the --threads option is experimental\n")
+		fmt.Fprintf(out, "Warning: the --threads option is experimental\n")
 	}
 	var wg sync.WaitGroup
 	for i := 0; i < noOfWorkers; i++ {
@@ -142,17 +301,225 @@ func runTestall(cmd *cobra.Command, cliArguments []string) {
 		go worker(&wg, i)
 	}
 
+	// On SIGINT/SIGTERM stop dispatching libraries and let the workers
+	// complete the ones in progress; a second signal aborts immediately,
+	// discarding the results of the libraries in progress
+	interrupted := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		dashboard.SetStatus("Interrupted, waiting for the libraries in progress to complete (interrupt again to abort)")
+		fmt.Fprintf(out, "\nInterrupted, waiting for the libraries in progress to complete (interrupt again to abort)\n")
+		close(interrupted)
+		<-signals
+		dashboard.Stop()
+		if junitWriter != nil {
+			junitWriter.Close()
+		}
+		fmt.Fprintf(os.Stderr, "\nAborted, use --resume to continue the run\n")
+		stream.Emit(runFinished(true))
+		stream.Close()
+		os.Exit(130)
+	}()
+
+	fmt.Fprintf(out, "Total libraries: %d\n", len(libNames))
+dispatch:
+	for _, lib := range libNames {
+		select {
+		case jobs <- lib:
+		case <-interrupted:
+			break dispatch
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+	if junitWriter != nil {
+		closeJUnit(junitWriter)
+	}
+
+	select {
+	case <-interrupted:
+		stream.Emit(runFinished(true))
+		if dashboard != nil {
+			dashboard.Stop()
+			dashboard.Summary(os.Stdout)
+		}
+		fmt.Printf("Run interrupted, use --resume to continue\n")
+		stream.Close()
+		os.Exit(130)
+	default:
+		runJournal.Remove()
+		if !changelogSyncedAt.IsZero() {
+			consumeChangelog(changelogSyncedAt)
+		}
+		stream.Emit(runFinished(false))
+		if dashboard != nil {
+			dashboard.Stop()
+			dashboard.Summary(os.Stdout)
+		}
+	}
+
+	if gate != nil {
+		gate.Finish(os.Stdout)
+	}
+}
+
+// testallLibraries returns the sorted list of the libraries to test. If no
+// libraries were supplied as arguments, all the installed ones will be used.
+func testallLibraries(cmd *cobra.Command, cliArguments []string, instance *cliclient.CliInstance) []string {
+	libraries := make(map[string]string) // unsanitized name => version
+	{
+		var libs []string
+		if len(cliArguments) == 0 {
+			libs = instance.GetInstalledLibraries()
+		} else {
+			// Parse arguments as glob patterns, allowing filters such as "Arduino_*"
+			for _, arg := range cliArguments {
+				g := glob.MustCompile(arg)
+				for _, lib := range instance.GetInstalledLibraries() {
+					if g.Match(lib) {
+						libs = append(libs, lib)
+					}
+				}
+			}
+		}
+		for _, lib := range libs {
+			t := strings.SplitN(lib, "@", 2)
+			version := ""
+			if len(t) > 1 {
+				version = t[1]
+			}
+			libraries[t[0]] = version
+		}
+	}
+
+	// Restrict the list to the libraries changed by the last installall run
+	if lastSync, _ := cmd.Flags().GetBool("last-sync"); lastSync {
+		changelog, err := libindex.LoadChangelog(util.ChangelogPath())
+		if err != nil {
+			util.Fatalf("Unable to read the changelog of the last sync: %v\n", err)
+		}
+		changed := make(map[string]bool)
+		for _, lib := range changelog.Changed() {
+			changed[lib] = true
+		}
+		for lib := range libraries {
+			if !changed[lib] {
+				delete(libraries, lib)
+			}
+		}
+	}
+
 	// Sort libraries alphabetically
 	libNames := make([]string, 0, len(libraries))
 	for lib := range libraries {
 		libNames = append(libNames, lib)
 	}
 	sort.Strings(libNames)
-	fmt.Printf("Total libraries: %d\n", len(libNames))
-	for _, lib := range libNames {
-		jobs <- lib
+	return libNames
+}
+
+// consumeChangelog marks the changes of the last syncs as tested, unless
+// installall changed them since they were read.
+func consumeChangelog(syncedAt time.Time) {
+	changelog, err := libindex.LoadChangelog(util.ChangelogPath())
+	if err != nil || !changelog.SyncedAt.Equal(syncedAt) {
+		return
 	}
-	close(jobs)
+	changelog.Consumed = true
+	if err := changelog.Save(util.ChangelogPath()); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not save library changelog: %v\n", err)
+	}
+}
 
-	wg.Wait()
+// shardLibraries returns the libraries belonging to the given shard. The
+// partitioning is deterministic, so that all the shards of a run agree on
+// it as long as they see the same libraries and durations. Balancing by
+// duration requires the durations, and falls back to a hash of the names
+// without them.
+func shardLibraries(libNames []string, shardArg string, balance string, durationsSource compare.Baseline) ([]string, error) {
+	var shard, numShards int
+	if _, err := fmt.Sscanf(shardArg, "%d/%d", &shard, &numShards); err != nil || numShards < 1 || shard < 1 || shard > numShards {
+		return nil, fmt.Errorf("%s: expected i/n with 1 <= i <= n", shardArg)
+	}
+
+	if balance == "duration" && durationsSource == nil {
+		fmt.Fprintf(os.Stderr, "Warning: no --shard-durations given, distributing libraries by a hash of their names\n")
+		balance = "hash"
+	}
+
+	var selected []string
+	switch balance {
+	case "hash":
+		// Libraries stay in the same shard whatever the others are
+		for _, lib := range libNames {
+			h := fnv.New32a()
+			h.Write([]byte(strings.ToLower(lib)))
+			if int(h.Sum32()%uint32(numShards)) == shard-1 {
+				selected = append(selected, lib)
+			}
+		}
+	case "name":
+		// Round-robin on the sorted list
+		for i, lib := range libNames {
+			if i%numShards == shard-1 {
+				selected = append(selected, lib)
+			}
+		}
+	case "duration":
+		// Assign the longest libraries first, each one to the shard with the
+		// lowest total so far. Libraries never tested are assumed to take
+		// the average time.
+		durations := libraryDurations(libNames, durationsSource)
+		var total time.Duration
+		for _, d := range durations {
+			total += d
+		}
+		average := time.Second
+		if len(durations) > 0 {
+			average = total / time.Duration(len(durations))
+		}
+		for _, lib := range libNames {
+			if durations[lib] == 0 {
+				durations[lib] = average
+			}
+		}
+		sorted := append([]string{}, libNames...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return durations[sorted[i]] > durations[sorted[j]]
+		})
+		totals := make([]time.Duration, numShards)
+		for _, lib := range sorted {
+			min := 0
+			for i := range totals {
+				if totals[i] < totals[min] {
+					min = i
+				}
+			}
+			totals[min] += durations[lib]
+			if min == shard-1 {
+				selected = append(selected, lib)
+			}
+		}
+		sort.Strings(selected)
+	default:
+		return nil, fmt.Errorf("unknown balancing method: %s", balance)
+	}
+	fmt.Printf("Shard %d/%d: %d of %d libraries\n", shard, numShards, len(selected), len(libNames))
+	return selected, nil
+}
+
+// libraryDurations returns the time spent testing each library in its last
+// tests, for the libraries where it's known.
+func libraryDurations(libNames []string, results compare.Baseline) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, lib := range libNames {
+		tr, _ := results.Get(lib)
+		if d := tr.Duration(); d > 0 {
+			durations[lib] = d
+		}
+	}
+	return durations
 }
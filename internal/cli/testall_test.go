@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// durations is a baseline where each library took the given seconds to test.
+type durations map[string]float64
+
+func (d durations) Get(lib string) (test.TestResults, error) {
+	seconds, ok := d[lib]
+	if !ok {
+		return test.TestResults{}, nil
+	}
+	return test.TestResults{Name: lib, Tests: []test.TestResult{{FQBN: "arduino:avr:uno", Duration: seconds}}}, nil
+}
+
+func TestShardLibraries(t *testing.T) {
+	var libs []string
+	for i := 0; i < 20; i++ {
+		libs = append(libs, fmt.Sprintf("Lib%02d", i))
+	}
+
+	for _, balance := range []string{"hash", "name", "duration"} {
+		// Every library ends up in exactly one shard
+		var all []string
+		for shard := 1; shard <= 3; shard++ {
+			selected, err := shardLibraries(libs, fmt.Sprintf("%d/3", shard), balance, durations{})
+			if err != nil {
+				t.Fatalf("%s: %v", balance, err)
+			}
+			all = append(all, selected...)
+		}
+		sort.Strings(all)
+		if !reflect.DeepEqual(all, libs) {
+			t.Errorf("%s: shards cover %v, want %v", balance, all, libs)
+		}
+	}
+
+	// Hashing keeps a library in the same shard whatever the others are
+	first, _ := shardLibraries(libs, "1/3", "hash", nil)
+	inFirst := make(map[string]bool)
+	for _, lib := range first {
+		inFirst[lib] = true
+	}
+	fewer, _ := shardLibraries(libs[5:], "1/3", "hash", nil)
+	for _, lib := range libs[5:] {
+		if inFirst[lib] != contains(fewer, lib) {
+			t.Errorf("%s moved to another shard", lib)
+		}
+	}
+
+	for _, arg := range []string{"0/3", "4/3", "1/0", "1", "a/b"} {
+		if _, err := shardLibraries(libs, arg, "name", nil); err == nil {
+			t.Errorf("invalid shard %s accepted", arg)
+		}
+	}
+	if _, err := shardLibraries(libs, "1/2", "size", nil); err == nil {
+		t.Errorf("unknown balancing method accepted")
+	}
+}
+
+func TestShardLibrariesByDuration(t *testing.T) {
+	// The longest library gets a shard on its own; the library never tested
+	// is assumed to take the average time
+	source := durations{"Long": 100, "A": 10, "B": 10, "C": 10, "D": 10}
+	libs := []string{"A", "B", "C", "D", "Long", "New"}
+	want := [][]string{{"Long"}, {"A", "B", "C", "D", "New"}}
+	for i, w := range want {
+		got, err := shardLibraries(libs, fmt.Sprintf("%d/2", i+1), "duration", source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("shard %d/2 = %v, want %v", i+1, got, w)
+		}
+	}
+
+	// Without durations, libraries are distributed by hash
+	byHash, _ := shardLibraries(libs, "1/2", "hash", nil)
+	if got, _ := shardLibraries(libs, "1/2", "duration", nil); !reflect.DeepEqual(got, byHash) {
+		t.Errorf("duration without a source = %v, want %v", got, byHash)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
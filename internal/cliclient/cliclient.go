@@ -3,6 +3,7 @@ package cliclient
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"os"
@@ -10,6 +11,12 @@ import (
 	"strings"
 
 	"github.com/alranel/arduino-testlib/internal/configuration"
+	"github.com/alranel/arduino-testlib/internal/download"
+	"github.com/alranel/arduino-testlib/internal/libindex"
+	"github.com/alranel/arduino-testlib/internal/pkgindex"
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/arduino/arduino-cli/arduino/cores"
+	cli_globals "github.com/arduino/arduino-cli/cli/globals"
 	cli_instance "github.com/arduino/arduino-cli/cli/instance"
 	cli_output "github.com/arduino/arduino-cli/cli/output"
 	cli_commands "github.com/arduino/arduino-cli/commands"
@@ -28,10 +35,33 @@ type CliInstance struct {
 func NewInstance() *CliInstance {
 	cli_conf.Settings = cli_conf.Init("")
 	logrus.SetLevel(logrus.ErrorLevel)
+
+	// The progress of the initialization of the instance always goes to
+	// stdout: silence it if the progress is redirected
+	if Progress != nil && Progress != os.Stdout {
+		cli_output.OutputFormat = "json"
+	}
 	cli_conf.Settings.Set("directories.Data", path.Join(configuration.CLIDataDir, "data"))
 	cli_conf.Settings.Set("directories.Downloads", path.Join(configuration.CLIDataDir, "downloads"))
 	cli_conf.Settings.Set("directories.User", path.Join(configuration.CLIDataDir, "user"))
-	if configuration.AdditionalURLs != "" {
+	libraryIndexPath := path.Join(cli_conf.Settings.GetString("directories.Data"), "library_index.json")
+	if configuration.Mirror != "" {
+		// The mirror already includes the packages from the additional URLs
+		cli_globals.DefaultIndexURL = util.MirrorURL("package_index.json")
+
+		// The library index of the mirror is not signed so it can't be
+		// downloaded by arduino-cli: fetch it before the instance is created
+		index, err := libindex.LoadSource(util.MirrorURL("library_index.json"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading library index from mirror: %v\n", err)
+			util.Exit(1)
+		}
+		os.MkdirAll(path.Dir(libraryIndexPath), os.ModePerm)
+		if err := index.Save(libraryIndexPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing library index: %v\n", err)
+			util.Exit(1)
+		}
+	} else if configuration.AdditionalURLs != "" {
 		cli_conf.Settings.Set("board_manager.additional_urls", strings.Split(configuration.AdditionalURLs, ","))
 	}
 	instance := new(CliInstance)
@@ -41,21 +71,41 @@ func NewInstance() *CliInstance {
 	{
 		_, err := cli_commands.UpdateIndex(context.Background(), &cli_rpc.UpdateIndexRequest{
 			Instance: instance.Instance,
-		}, cli_output.ProgressBar())
+		}, downloadProgress())
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error updating index: %v", err)
-			os.Exit(1)
+			util.Exit(1)
 		}
 	}
 
 	// Update library index
-	{
+	if configuration.Mirror == "" {
 		err := cli_commands.UpdateLibrariesIndex(context.Background(), &cli_rpc.UpdateLibrariesIndexRequest{
 			Instance: instance.Instance,
-		}, cli_output.ProgressBar())
+		}, downloadProgress())
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error updating library index: %v", err)
-			os.Exit(1)
+			util.Exit(1)
+		}
+	}
+
+	// Merge the additional library indexes into the one used by arduino-cli
+	if len(configuration.AdditionalLibraryIndexes) > 0 {
+		index, err := libindex.LoadWithAdditional(libraryIndexPath, configuration.AdditionalLibraryIndexes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading additional library indexes: %v\n", err)
+			util.Exit(1)
+		}
+		if err := index.Save(libraryIndexPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing library index: %v\n", err)
+			util.Exit(1)
+		}
+	}
+
+	// Reload the instance so that it sees the updated indexes
+	if configuration.Mirror != "" || len(configuration.AdditionalLibraryIndexes) > 0 {
+		for _, err := range cli_instance.Init(instance.Instance) {
+			fmt.Fprintf(os.Stderr, "Error initializing instance: %v\n", err)
 		}
 	}
 
@@ -63,7 +113,7 @@ func NewInstance() *CliInstance {
 }
 
 func (instance *CliInstance) InstallLibrary(libName string, version string) bool {
-	fmt.Printf("=> Installing lib: %s\n", libName)
+	fmt.Fprintf(progressOutput(), "=> Installing lib: %s\n", libName)
 
 	libraryInstallRequest := &cli_rpc.LibraryInstallRequest{
 		Instance: instance.Instance,
@@ -71,7 +121,7 @@ func (instance *CliInstance) InstallLibrary(libName string, version string) bool
 		Version:  version,
 		NoDeps:   true,
 	}
-	err := cli_lib.LibraryInstall(context.Background(), libraryInstallRequest, cli_output.ProgressBar(), cli_output.TaskProgress())
+	err := cli_lib.LibraryInstall(context.Background(), libraryInstallRequest, downloadProgress(), taskProgress())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error installing %s: %v\n", libName, err)
 		return false
@@ -81,6 +131,12 @@ func (instance *CliInstance) InstallLibrary(libName string, version string) bool
 }
 
 func (instance *CliInstance) InstallCores() {
+	// arduino-cli can't download archives from file:// URLs, so copy them
+	// from the mirror to the downloads directory where they will be found
+	if strings.HasPrefix(configuration.Mirror, "file://") {
+		instance.seedDownloadsFromMirror()
+	}
+
 	for _, fqbn := range configuration.FQBNs {
 		t := strings.Split(fqbn, ":")
 		platformInstallRequest := &cli_rpc.PlatformInstallRequest{
@@ -90,13 +146,37 @@ func (instance *CliInstance) InstallCores() {
 			Version:         "",
 			SkipPostInstall: false,
 		}
-		_, err := cli_core.PlatformInstall(context.Background(), platformInstallRequest, cli_output.ProgressBar(), cli_output.TaskProgress())
+		_, err := cli_core.PlatformInstall(context.Background(), platformInstallRequest, downloadProgress(), taskProgress())
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error installing %s: %v", fqbn, err)
 		}
 	}
 }
 
+func (instance *CliInstance) seedDownloadsFromMirror() {
+	index, err := pkgindex.Load(strings.TrimPrefix(util.MirrorURL("package_index.json"), "file://"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading package index from mirror: %v\n", err)
+		util.Exit(1)
+	}
+	downloadsDir := path.Join(cli_conf.Settings.GetString("directories.Downloads"), "packages")
+	os.MkdirAll(downloadsDir, os.ModePerm)
+	var jobs []download.Job
+	for _, a := range index.Archives() {
+		jobs = append(jobs, download.Job{
+			URL:      a.URL,
+			Path:     path.Join(downloadsDir, a.ArchiveFileName),
+			Checksum: a.Checksum,
+			Size:     a.Size,
+		})
+	}
+	download.All(jobs, 1, 0, func(job download.Job, cached bool, err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error copying %s from mirror: %v\n", job.URL, err)
+		}
+	})
+}
+
 func (instance *CliInstance) GetAllLibraries() []string {
 	res, err := cli_lib.LibrarySearch(context.Background(), &cli_rpc.LibrarySearchRequest{
 		Instance: instance.Instance,
@@ -104,7 +184,7 @@ func (instance *CliInstance) GetAllLibraries() []string {
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error listing libraries: %v", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	var libs []string
@@ -121,7 +201,7 @@ func (instance *CliInstance) GetInstalledLibraries() []string {
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error listing libraries: %v", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	var libs []string
@@ -131,6 +211,25 @@ func (instance *CliInstance) GetInstalledLibraries() []string {
 	return libs
 }
 
+// GetInstalledLibraryVersions returns the version of each library installed
+// in the user directory.
+func (instance *CliInstance) GetInstalledLibraryVersions() map[string]string {
+	res, err := cli_lib.LibraryList(context.Background(), &cli_rpc.LibraryListRequest{
+		Instance: instance.Instance,
+		All:      false,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing libraries: %v", err)
+		util.Exit(1)
+	}
+
+	versions := make(map[string]string)
+	for _, lib := range res.GetInstalledLibraries() {
+		versions[lib.Library.Name] = lib.Library.Version
+	}
+	return versions
+}
+
 func (instance *CliInstance) GetInstalledCoreVersion(core string) (string, error) {
 	platforms, err := cli_core.GetPlatforms(&cli_rpc.PlatformListRequest{
 		Instance:      instance.Instance,
@@ -139,7 +238,7 @@ func (instance *CliInstance) GetInstalledCoreVersion(core string) (string, error
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error listing platforms: %v", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 	for _, p := range platforms {
 		if p.Id == core {
@@ -149,21 +248,73 @@ func (instance *CliInstance) GetInstalledCoreVersion(core string) (string, error
 	return "", errors.New("Platform not found")
 }
 
-func (instance *CliInstance) CompileSketch(sketchPath string, libPath string, fqbn string) (result bool, out string) {
+// GetPlatformIdentity returns a description of the platform used to compile
+// for a board, which changes whenever the compilation might: the platform
+// release (and the one providing the core, if different), its tool
+// dependencies and the hashes of its boards.txt and platform.txt.
+func (instance *CliInstance) GetPlatformIdentity(fqbn string) (string, error) {
+	parsedFQBN, err := cores.ParseFQBN(fqbn)
+	if err != nil {
+		return "", err
+	}
+	pm := cli_commands.GetPackageManager(instance.Instance.GetId())
+	_, platform, _, _, buildPlatform, err := pm.ResolveFQBN(parsedFQBN)
+	if err != nil {
+		return "", err
+	}
+	platforms := []*cores.PlatformRelease{platform}
+	if buildPlatform != nil && buildPlatform != platform {
+		platforms = append(platforms, buildPlatform)
+	}
+
+	var identity strings.Builder
+	for _, p := range platforms {
+		fmt.Fprintf(&identity, "%s\n", p)
+		for _, dep := range p.ToolDependencies {
+			fmt.Fprintf(&identity, "%s\n", dep)
+		}
+		for _, file := range []string{"boards.txt", "platform.txt"} {
+			data, err := p.InstallDir.Join(file).ReadFile()
+			if err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+			fmt.Fprintf(&identity, "%s %x\n", file, sha256.Sum256(data))
+		}
+	}
+	return identity.String(), nil
+}
+
+// CompileSketch compiles a sketch, returning the compilation output and the
+// versions of the libraries from the user directory used by the sketch.
+// These are only known when the compilation succeeds. warnings is the
+// compiler warnings level (none, default, more or all); with an empty one
+// GCC runs with -w, and the output only shows errors.
+func (instance *CliInstance) CompileSketch(sketchPath string, libPath string, fqbn string, warnings string) (result bool, out string, usedLibraries map[string]string) {
 	compileRequest := &cli_rpc.CompileRequest{
 		Instance:   instance.Instance,
 		Fqbn:       fqbn,
 		SketchPath: sketchPath,
 		Library:    []string{libPath},
+		Warnings:   warnings,
 	}
 	compileStdOut := new(bytes.Buffer)
 	compileStdErr := new(bytes.Buffer)
 	verboseCompile := false
-	_, compileError := cli_compile.Compile(context.Background(), compileRequest, compileStdOut, compileStdErr, nil, verboseCompile)
+	res, compileError := cli_compile.Compile(context.Background(), compileRequest, compileStdOut, compileStdErr, nil, verboseCompile)
+
+	for _, lib := range res.GetUsedLibraries() {
+		if lib.GetLocation() != cli_rpc.LibraryLocation_LIBRARY_LOCATION_USER {
+			continue
+		}
+		if usedLibraries == nil {
+			usedLibraries = make(map[string]string)
+		}
+		usedLibraries[lib.GetName()] = lib.GetVersion()
+	}
 
 	if compileError == nil {
-		return true, compileStdOut.String() + compileStdErr.String()
+		return true, compileStdOut.String() + compileStdErr.String(), usedLibraries
 	} else {
-		return false, compileStdOut.String() + compileStdErr.String()
+		return false, compileStdOut.String() + compileStdErr.String(), usedLibraries
 	}
 }
@@ -0,0 +1,77 @@
+package cliclient
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	cli_rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
+	"github.com/cmaglie/pb"
+)
+
+// Progress receives the progress of the downloads and installations done by
+// arduino-cli; it's stdout if nil. Commands writing machine-readable output
+// to stdout send it to stderr, or discard it with ioutil.Discard.
+var Progress io.Writer
+
+func progressOutput() io.Writer {
+	if Progress == nil {
+		return os.Stdout
+	}
+	return Progress
+}
+
+// downloadProgress returns a callback showing a progress bar for each
+// download, like the one of arduino-cli but writing to Progress.
+func downloadProgress() cli_rpc.DownloadProgressCB {
+	out := progressOutput()
+	var bar *pb.ProgressBar
+	var prefix string
+	return func(curr *cli_rpc.DownloadProgress) {
+		if filename := curr.GetFile(); filename != "" {
+			if curr.GetCompleted() {
+				fmt.Fprintf(out, "%s already downloaded\n", filename)
+				return
+			}
+			prefix = filename
+			bar = pb.New(int(curr.GetTotalSize()))
+			bar.Output = out
+			bar.Prefix(prefix)
+			bar.SetUnits(pb.U_BYTES)
+			bar.Start()
+		}
+		if bar == nil {
+			return
+		}
+		if curr.GetDownloaded() != 0 {
+			bar.Set(int(curr.GetDownloaded()))
+		}
+		if curr.GetCompleted() {
+			bar.FinishPrintOver(prefix + " downloaded")
+		}
+	}
+}
+
+// taskProgress returns a callback printing the installation tasks to
+// Progress.
+func taskProgress() cli_rpc.TaskProgressCB {
+	out := progressOutput()
+	var name string
+	return func(curr *cli_rpc.TaskProgress) {
+		msg := curr.GetMessage()
+		if curr.GetName() != "" {
+			name = curr.GetName()
+			if msg == "" {
+				msg = name
+			}
+		}
+		if msg == "" {
+			return
+		}
+		if curr.GetCompleted() {
+			fmt.Fprintln(out, msg)
+		} else {
+			fmt.Fprintln(out, msg+"...")
+		}
+	}
+}
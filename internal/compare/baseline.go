@@ -0,0 +1,59 @@
+package compare
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/alranel/arduino-testlib/internal/store"
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// Baseline provides the results to compare with.
+type Baseline interface {
+	Get(lib string) (test.TestResults, error)
+}
+
+// resultsFile is a baseline read from a JSON file, such as the output of the
+// test command.
+type resultsFile map[string]test.TestResults // lowercase name => results
+
+func (f resultsFile) Get(lib string) (test.TestResults, error) {
+	return f[strings.ToLower(lib)], nil
+}
+
+// OpenBaseline opens a baseline, which can be a datadir or a JSON file with
+// the results of one library or an array of them. The store of a datadir is
+// detected from its contents.
+func OpenBaseline(baselinePath string) (Baseline, error) {
+	info, err := os.Stat(baselinePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return store.Open(store.Detect(baselinePath), baselinePath)
+	}
+
+	byteValue, err := ioutil.ReadFile(baselinePath)
+	if err != nil {
+		return nil, err
+	}
+	var results []test.TestResults
+	if strings.HasPrefix(strings.TrimSpace(string(byteValue)), "[") {
+		err = json.Unmarshal(byteValue, &results)
+	} else {
+		var tr test.TestResults
+		err = json.Unmarshal(byteValue, &tr)
+		results = append(results, tr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", baselinePath, err)
+	}
+	f := make(resultsFile)
+	for _, tr := range results {
+		f[strings.ToLower(tr.Name)] = tr
+	}
+	return f, nil
+}
@@ -0,0 +1,154 @@
+package compare
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// Kind is the kind of a change between two results of a library/board pair.
+type Kind string
+
+const (
+	Regression        Kind = "regression"         // PASS to FAIL
+	Fix               Kind = "fix"                // FAIL to PASS
+	ExampleRegression Kind = "example_regression" // example failing, not failing before
+	ExampleFix        Kind = "example_fix"        // example passing, failing before
+	NewFailClaim      Kind = "new_fail_claim"     // FAIL_CLAIM, not FAIL_CLAIM before
+)
+
+// Change describes how the result of a library/board pair changed.
+type Change struct {
+	Library string                   `json:"library"`
+	FQBN    string                   `json:"fqbn"`
+	Kind    Kind                     `json:"kind"`
+	Example string                   `json:"example,omitempty"`
+	Before  test.CompatibilityStatus `json:"before,omitempty"` // empty if not tested before
+	After   test.CompatibilityStatus `json:"after"`
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case Regression:
+		return fmt.Sprintf("%s on %s: PASS -> FAIL", c.Library, c.FQBN)
+	case Fix:
+		return fmt.Sprintf("%s on %s: FAIL -> PASS", c.Library, c.FQBN)
+	case ExampleRegression:
+		return fmt.Sprintf("%s on %s: example %s newly failing", c.Library, c.FQBN, c.Example)
+	case ExampleFix:
+		return fmt.Sprintf("%s on %s: example %s fixed", c.Library, c.FQBN, c.Example)
+	case NewFailClaim:
+		return fmt.Sprintf("%s on %s: new FAIL_CLAIM (was %s)", c.Library, c.FQBN, statusOrUntested(c.Before))
+	}
+	return fmt.Sprintf("%s on %s: %s", c.Library, c.FQBN, c.Kind)
+}
+
+func statusOrUntested(s test.CompatibilityStatus) string {
+	if s == "" {
+		return "untested"
+	}
+	return string(s)
+}
+
+// Compare returns the changes between the last results of each of the given
+// FQBNs in base and head. All the FQBNs found in head are compared if none
+// are given.
+func Compare(base test.TestResults, head test.TestResults, fqbns []string) []Change {
+	baseTests := base.LastTests()
+	headTests := head.LastTests()
+	if len(fqbns) == 0 {
+		for fqbn := range headTests {
+			fqbns = append(fqbns, fqbn)
+		}
+		sort.Strings(fqbns)
+	}
+	name := head.Name
+	if name == "" {
+		name = base.Name
+	}
+
+	var changes []Change
+	for _, fqbn := range fqbns {
+		h, ok := headTests[fqbn]
+		if !ok {
+			continue
+		}
+		b, tested := baseTests[fqbn]
+		change := Change{Library: name, FQBN: fqbn, After: h.Status()}
+		if tested {
+			change.Before = b.Status()
+		}
+		add := func(kind Kind, example string) {
+			c := change
+			c.Kind = kind
+			c.Example = example
+			changes = append(changes, c)
+		}
+
+		if tested && b.Result == test.PASS && h.Result == test.FAIL {
+			add(Regression, "")
+		} else if tested && b.Result == test.FAIL && h.Result == test.PASS {
+			add(Fix, "")
+		}
+		if h.Status() == test.FAIL_CLAIM && change.Before != test.FAIL_CLAIM {
+			add(NewFailClaim, "")
+		}
+
+		// Examples are only compared when the pair was tested before, so
+		// that a new board doesn't count all its failing examples
+		if tested {
+			baseExamples := make(map[string]test.CompilationResult)
+			for _, e := range b.Examples {
+				baseExamples[e.Name] = e.Result
+			}
+			for _, e := range h.Examples {
+				if e.Result == test.FAIL && baseExamples[e.Name] != test.FAIL {
+					add(ExampleRegression, e.Name)
+				} else if e.Result == test.PASS && baseExamples[e.Name] == test.FAIL {
+					add(ExampleFix, e.Name)
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// Thresholds are the maximum numbers of regressions and new FAIL_CLAIM
+// pairs that are tolerated.
+type Thresholds struct {
+	MaxRegressions   int
+	MaxNewFailClaims int
+}
+
+// Summary accumulates the changes of several libraries.
+type Summary struct {
+	Changes []Change
+}
+
+func (s *Summary) Add(changes ...Change) {
+	s.Changes = append(s.Changes, changes...)
+}
+
+// Count returns the number of changes of the given kinds.
+func (s *Summary) Count(kinds ...Kind) int {
+	n := 0
+	for _, c := range s.Changes {
+		for _, k := range kinds {
+			if c.Kind == k {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Regressions returns the number of pairs or examples that started failing.
+func (s *Summary) Regressions() int {
+	return s.Count(Regression, ExampleRegression)
+}
+
+// Exceeds checks whether the changes are more than the thresholds tolerate.
+func (s *Summary) Exceeds(t Thresholds) bool {
+	return s.Regressions() > t.MaxRegressions || s.Count(NewFailClaim) > t.MaxNewFailClaims
+}
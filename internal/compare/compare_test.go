@@ -0,0 +1,90 @@
+package compare
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+func results(tests ...test.TestResult) test.TestResults {
+	return test.TestResults{Name: "Foo", Tests: tests}
+}
+
+func result(fqbn string, res test.CompilationResult, examples ...test.ExampleResult) test.TestResult {
+	return test.TestResult{Version: "1.0.0", FQBN: fqbn, Core: strings.Join(strings.Split(fqbn, ":")[:2], ":"), Architectures: []string{"avr"}, Result: res, Examples: examples}
+}
+
+func example(name string, res test.CompilationResult) test.ExampleResult {
+	return test.ExampleResult{Name: name, Result: res}
+}
+
+func TestCompare(t *testing.T) {
+	const uno, due = "arduino:avr:uno", "arduino:sam:arduino_due_x"
+	base := results(
+		result(uno, test.PASS, example("Blink", test.PASS), example("Read", test.FAIL)),
+		result(due, test.FAIL),
+	)
+	head := results(
+		result(uno, test.FAIL, example("Blink", test.FAIL), example("Read", test.PASS), example("New", test.FAIL)),
+		result(due, test.PASS),
+	)
+	want := []Change{
+		{Library: "Foo", FQBN: uno, Kind: Regression, Before: test.PASS_CLAIM, After: test.FAIL_CLAIM},
+		{Library: "Foo", FQBN: uno, Kind: NewFailClaim, Before: test.PASS_CLAIM, After: test.FAIL_CLAIM},
+		{Library: "Foo", FQBN: uno, Kind: ExampleRegression, Example: "Blink", Before: test.PASS_CLAIM, After: test.FAIL_CLAIM},
+		{Library: "Foo", FQBN: uno, Kind: ExampleFix, Example: "Read", Before: test.PASS_CLAIM, After: test.FAIL_CLAIM},
+		{Library: "Foo", FQBN: uno, Kind: ExampleRegression, Example: "New", Before: test.PASS_CLAIM, After: test.FAIL_CLAIM},
+		{Library: "Foo", FQBN: due, Kind: Fix, Before: test.FAIL_NOCLAIM, After: test.PASS_NOCLAIM},
+	}
+	if got := Compare(base, head, []string{uno, due}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare = %+v\nwant %+v", got, want)
+	}
+
+	// The FQBNs are taken from head when not given
+	if got := Compare(base, head, nil); len(got) != len(want) {
+		t.Errorf("Compare without FQBNs returned %d changes, want %d", len(got), len(want))
+	}
+
+	// Only the given FQBNs are compared
+	if got := Compare(base, head, []string{due}); !reflect.DeepEqual(got, want[5:]) {
+		t.Errorf("Compare on %s = %+v, want %+v", due, got, want[5:])
+	}
+}
+
+func TestCompareNewBoard(t *testing.T) {
+	const uno = "arduino:avr:uno"
+	head := results(result(uno, test.FAIL, example("Blink", test.FAIL)))
+
+	// The examples of a board not tested before are not compared
+	want := []Change{{Library: "Foo", FQBN: uno, Kind: NewFailClaim, After: test.FAIL_CLAIM}}
+	if got := Compare(test.TestResults{}, head, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare = %+v, want %+v", got, want)
+	}
+
+	// A FAIL_CLAIM already failing is not new
+	if got := Compare(head, head, nil); len(got) != 0 {
+		t.Errorf("Compare with itself = %+v, want no changes", got)
+	}
+}
+
+func TestSummaryExceeds(t *testing.T) {
+	var s Summary
+	s.Add(Change{Kind: Regression}, Change{Kind: ExampleRegression}, Change{Kind: Fix}, Change{Kind: NewFailClaim})
+	if n := s.Regressions(); n != 2 {
+		t.Errorf("Regressions = %d, want 2", n)
+	}
+	for _, c := range []struct {
+		thresholds Thresholds
+		want       bool
+	}{
+		{Thresholds{MaxRegressions: 2, MaxNewFailClaims: 1}, false},
+		{Thresholds{MaxRegressions: 1, MaxNewFailClaims: 1}, true},
+		{Thresholds{MaxRegressions: 2, MaxNewFailClaims: 0}, true},
+	} {
+		if got := s.Exceeds(c.thresholds); got != c.want {
+			t.Errorf("Exceeds(%+v) = %v, want %v", c.thresholds, got, c.want)
+		}
+	}
+}
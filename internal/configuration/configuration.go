@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 
 	"github.com/spf13/pflag"
 	"gopkg.in/yaml.v1"
@@ -12,6 +13,8 @@ import (
 
 var CLIDataDir, CLIUserDir string
 var AdditionalURLs string
+var AdditionalLibraryIndexes []string
+var Mirror string
 var FQBNs []string
 
 func Initialize(flags *pflag.FlagSet) error {
@@ -38,7 +41,11 @@ func Initialize(flags *pflag.FlagSet) error {
 	//fmt.Printf("CLI user dir = %s\n", CLIUserDir)
 
 	AdditionalURLs, _ = flags.GetString("additional-urls")
+	if indexes, _ := flags.GetString("additional-library-indexes"); indexes != "" {
+		AdditionalLibraryIndexes = strings.Split(indexes, ",")
+	}
 	FQBNs, _ = flags.GetStringSlice("fqbn")
+	Mirror, _ = flags.GetString("mirror")
 
 	return nil
 }
@@ -0,0 +1,165 @@
+package download
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job describes a file to be downloaded. Checksum and Size are optional and
+// are verified when set.
+type Job struct {
+	URL      string
+	Path     string
+	Checksum string // in the "SHA-256:<hex>" form used by the Arduino indexes
+	Size     int64
+}
+
+// Backoff is the delay before the first retry; it doubles at each attempt.
+var Backoff = time.Second
+
+var client = &http.Client{Timeout: 10 * time.Minute}
+
+// Verify checks that the file at path has the expected size and checksum.
+func Verify(path string, checksum string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if size > 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() != size {
+			return fmt.Errorf("size mismatch for %s: expected %d, got %d", path, size, info.Size())
+		}
+	}
+	if checksum == "" {
+		return nil
+	}
+
+	t := strings.SplitN(checksum, ":", 2)
+	if len(t) != 2 {
+		return fmt.Errorf("invalid checksum format: %s", checksum)
+	}
+	var h hash.Hash
+	switch strings.ToUpper(t[0]) {
+	case "SHA-256":
+		h = sha256.New()
+	case "SHA-1":
+		h = sha1.New()
+	case "MD5":
+		h = md5.New()
+	default:
+		return fmt.Errorf("unsupported checksum algorithm: %s", t[0])
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, t[1]) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", path, t[1], sum)
+	}
+	return nil
+}
+
+// File downloads a single file, unless a valid copy already exists at the
+// destination path. Failed attempts are retried with exponential backoff.
+func File(job Job, retries int) (cached bool, err error) {
+	if _, err := os.Stat(job.Path); err == nil && (job.Checksum != "" || job.Size > 0) {
+		if Verify(job.Path, job.Checksum, job.Size) == nil {
+			return true, nil
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = fetch(job)
+		if err == nil || attempt >= retries {
+			return false, err
+		}
+		time.Sleep(Backoff << attempt)
+	}
+}
+
+// fetch performs a single download attempt. Data is written to a temporary
+// file which is renamed only after verification, so that an interrupted
+// download never leaves a truncated archive behind.
+func fetch(job Job) error {
+	body, err := open(job.URL)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tmpPath := job.Path + ".part"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = Verify(tmpPath, job.Checksum, job.Size)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, job.Path)
+}
+
+// open returns a reader for a http(s):// or file:// URL.
+func open(rawURL string) (io.ReadCloser, error) {
+	if u, err := url.Parse(rawURL); err == nil && u.Scheme == "file" {
+		return os.Open(u.Path)
+	}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// All downloads the given files using a pool of parallel workers. The done
+// callback is invoked from the worker goroutines as soon as each job is
+// completed, so it must be safe for concurrent use.
+func All(jobs []Job, threads int, retries int, done func(job Job, cached bool, err error)) {
+	if threads < 1 {
+		threads = 1
+	}
+	queue := make(chan Job)
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				cached, err := File(job, retries)
+				done(job, cached, err)
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+}
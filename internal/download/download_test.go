@@ -0,0 +1,138 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+var archive = []byte("PK fake library archive")
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "SHA-256:" + hex.EncodeToString(sum[:])
+}
+
+// server serves the archive at /ok.zip, fails the first requests of
+// /flaky.zip and always fails /missing.zip. It counts the requests by path.
+func server(t *testing.T, flakyFailures int32) (*httptest.Server, map[string]*int32) {
+	requests := map[string]*int32{"/ok.zip": new(int32), "/flaky.zip": new(int32), "/missing.zip": new(int32)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, ok := requests[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		count := atomic.AddInt32(n, 1)
+		switch {
+		case r.URL.Path == "/missing.zip":
+			http.NotFound(w, r)
+		case r.URL.Path == "/flaky.zip" && count <= flakyFailures:
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		default:
+			w.Write(archive)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	backoff := Backoff
+	Backoff = 0
+	t.Cleanup(func() { Backoff = backoff })
+	return srv, requests
+}
+
+func TestFileRetries(t *testing.T) {
+	srv, requests := server(t, 2)
+	job := Job{URL: srv.URL + "/flaky.zip", Path: filepath.Join(t.TempDir(), "flaky.zip"), Checksum: checksum(archive), Size: int64(len(archive))}
+
+	if _, err := File(job, 1); err == nil {
+		t.Fatalf("download succeeded with fewer retries than failures")
+	}
+	if _, err := os.Stat(job.Path); !os.IsNotExist(err) {
+		t.Errorf("failed download left a file behind")
+	}
+	if _, err := File(job, 1); err != nil {
+		t.Fatalf("download failed after the server recovered: %v", err)
+	}
+	if n := atomic.LoadInt32(requests["/flaky.zip"]); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+}
+
+func TestFileChecksumMismatch(t *testing.T) {
+	srv, requests := server(t, 0)
+	job := Job{URL: srv.URL + "/ok.zip", Path: filepath.Join(t.TempDir(), "ok.zip"), Checksum: checksum([]byte("something else"))}
+
+	if _, err := File(job, 2); err == nil {
+		t.Fatalf("download with a wrong checksum succeeded")
+	}
+	if n := atomic.LoadInt32(requests["/ok.zip"]); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+	for _, p := range []string{job.Path, job.Path + ".part"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s was left behind", p)
+		}
+	}
+}
+
+func TestFileCached(t *testing.T) {
+	srv, requests := server(t, 0)
+	dir := t.TempDir()
+	job := Job{URL: srv.URL + "/ok.zip", Path: filepath.Join(dir, "ok.zip"), Checksum: checksum(archive)}
+
+	if err := os.WriteFile(job.Path, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := File(job, 0); err != nil || !cached {
+		t.Errorf("valid archive: cached = %v, err = %v", cached, err)
+	}
+
+	// A corrupt archive is downloaded again
+	if err := os.WriteFile(job.Path, []byte("truncated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := File(job, 0); err != nil || cached {
+		t.Errorf("corrupt archive: cached = %v, err = %v", cached, err)
+	}
+	if n := atomic.LoadInt32(requests["/ok.zip"]); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+	if err := Verify(job.Path, job.Checksum, int64(len(archive))); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAllFailures(t *testing.T) {
+	srv, _ := server(t, 1)
+	dir := t.TempDir()
+	var jobs []Job
+	for _, name := range []string{"ok.zip", "flaky.zip", "missing.zip"} {
+		jobs = append(jobs, Job{URL: srv.URL + "/" + name, Path: filepath.Join(dir, name), Checksum: checksum(archive)})
+	}
+
+	var mu sync.Mutex
+	var done, failed []string
+	All(jobs, 2, 1, func(job Job, cached bool, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		done = append(done, filepath.Base(job.Path))
+		if err != nil {
+			failed = append(failed, filepath.Base(job.Path))
+		}
+	})
+	if len(done) != len(jobs) {
+		t.Errorf("done called for %v, want all the %d jobs", done, len(jobs))
+	}
+	sort.Strings(failed)
+	if len(failed) != 1 || failed[0] != "missing.zip" {
+		t.Errorf("failed = %v, want [missing.zip]", failed)
+	}
+}
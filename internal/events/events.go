@@ -0,0 +1,184 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// Type is the type of an event.
+type Type string
+
+const (
+	RunStarted      Type = "run_started"
+	WorkerReady     Type = "worker_ready"
+	LibraryStarted  Type = "library_started"
+	CompileStarted  Type = "compile_started"
+	CompileFinished Type = "compile_finished"
+	LibraryFinished Type = "library_finished"
+	RunFinished     Type = "run_finished"
+)
+
+// Event describes the progress of a testall run. Only the fields relevant
+// to each type are set.
+type Event struct {
+	Type   Type      `json:"type"`
+	Time   time.Time `json:"time"`
+	Run    string    `json:"run,omitempty"`
+	Worker *int      `json:"worker,omitempty"`
+
+	// run_started
+	FQBNs []string `json:"fqbns,omitempty"`
+
+	// library_started, compile_started, compile_finished, library_finished
+	Library string `json:"library,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// compile_started, compile_finished
+	FQBN    string `json:"fqbn,omitempty"`
+	Example string `json:"example,omitempty"` // empty for the library inclusion
+
+	// compile_finished
+	Result test.CompilationResult `json:"result,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	Log    string                 `json:"-"` // only passed to handlers
+
+	// library_finished, run_finished
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Passed   int     `json:"passed"`             // compilations
+	Failed   int     `json:"failed"`             // compilations
+
+	// run_started, library_finished, run_finished
+	Done  int `json:"done"`            // libraries
+	Total int `json:"total,omitempty"` // libraries
+
+	// run_finished
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+// ForWorker returns the event attributed to a worker.
+func (e Event) ForWorker(id int) Event {
+	e.Worker = &id
+	return e
+}
+
+// Compile returns the compile_started or compile_finished event of a
+// compilation, depending on whether its result is known.
+func Compile(c test.CompileEvent) Event {
+	if c.Result == "" {
+		return Event{Type: CompileStarted, Library: c.Library, Version: c.Version, FQBN: c.FQBN, Example: c.Example}
+	}
+	return Event{
+		Type:     CompileFinished,
+		Library:  c.Library,
+		Version:  c.Version,
+		FQBN:     c.FQBN,
+		Example:  c.Example,
+		Result:   c.Result,
+		Error:    c.Error,
+		Duration: c.Duration,
+		Log:      c.Log,
+	}
+}
+
+// Stream delivers events to handlers, such as the JSON lines written by
+// Open. A nil *Stream discards them.
+type Stream struct {
+	mu       sync.Mutex
+	handlers []func(Event)
+	closers  []io.Closer
+	run      string
+}
+
+// SetRun sets the run recorded in the events emitted from now on: the start
+// time of the run in RFC 3339 format.
+func (s *Stream) SetRun(run string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.run = run
+}
+
+// Handle registers a function receiving all the events. Events are
+// delivered one at a time, so handlers don't need to be safe for concurrent
+// use.
+func (s *Stream) Handle(fn func(Event)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, fn)
+}
+
+// Open writes the events as JSON lines to a file, which is truncated, or to
+// a socket given as unix:/path/to/socket or tcp:host:port. Writing errors
+// disable the output rather than interrupting the run.
+func (s *Stream) Open(target string) error {
+	var w io.WriteCloser
+	var err error
+	switch {
+	case strings.HasPrefix(target, "unix:"):
+		w, err = net.Dial("unix", strings.TrimPrefix(target, "unix:"))
+	case strings.HasPrefix(target, "tcp:"):
+		w, err = net.Dial("tcp", strings.TrimPrefix(target, "tcp:"))
+	default:
+		w, err = os.Create(target)
+	}
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	failed := false
+	s.Handle(func(e Event) {
+		if failed {
+			return
+		}
+		if err := enc.Encode(e); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not write events, disabling them: %v\n", err)
+			failed = true
+		}
+	})
+	s.mu.Lock()
+	s.closers = append(s.closers, w)
+	s.mu.Unlock()
+	return nil
+}
+
+// Emit delivers an event, setting its time and the run set by SetRun. It's
+// safe to call from multiple goroutines.
+func (s *Stream) Emit(e Event) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.Time = time.Now().UTC()
+	e.Run = s.run
+	for _, fn := range s.handlers {
+		fn(e)
+	}
+}
+
+// Close closes the files and sockets opened by Open.
+func (s *Stream) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for _, c := range s.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	s.closers = nil
+	return err
+}
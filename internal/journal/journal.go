@@ -0,0 +1,121 @@
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alranel/arduino-testlib/internal/util"
+)
+
+// Journal records the plan and the progress of a testall run, so that an
+// interrupted run can be resumed where it stopped.
+type Journal struct {
+	StartedAt    time.Time           `json:"started_at"`
+	FQBNs        []string            `json:"fqbns"`
+	CoreVersions map[string]string   `json:"core_versions"` // core => version
+	Force        bool                `json:"force"`
+	Jobs         []string            `json:"jobs"`
+	Selection    map[string][]string `json:"selection,omitempty"` // lib => FQBNs, when restricted by selectors
+	Done         []string            `json:"done"`
+
+	path  string
+	mutex sync.Mutex
+}
+
+// Path returns the location of the journal in the given datadir. The file is
+// hidden so that it's not mistaken for a test results file.
+func Path(datadirPath string) string {
+	return path.Join(datadirPath, ".testall-journal.json")
+}
+
+func New(datadirPath string, fqbns []string, coreVersions map[string]string, force bool, jobs []string) *Journal {
+	return &Journal{
+		StartedAt:    time.Now(),
+		FQBNs:        fqbns,
+		CoreVersions: coreVersions,
+		Force:        force,
+		Jobs:         jobs,
+		Done:         []string{},
+		path:         Path(datadirPath),
+	}
+}
+
+func Load(datadirPath string) (*Journal, error) {
+	j := &Journal{path: Path(datadirPath)}
+	byteValue, err := ioutil.ReadFile(j.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(byteValue, j); err != nil {
+		return nil, fmt.Errorf("%s: %v", j.path, err)
+	}
+	return j, nil
+}
+
+// Matches checks that the run can be resumed with the given parameters.
+func (j *Journal) Matches(fqbns []string, coreVersions map[string]string) error {
+	a := append([]string{}, j.FQBNs...)
+	b := append([]string{}, fqbns...)
+	sort.Strings(a)
+	sort.Strings(b)
+	if strings.Join(a, ",") != strings.Join(b, ",") {
+		return fmt.Errorf("FQBNs changed: journal has %s, got %s", strings.Join(a, ","), strings.Join(b, ","))
+	}
+	for core, version := range j.CoreVersions {
+		if coreVersions[core] != version {
+			return fmt.Errorf("version of %s changed: journal has %s, got %s", core, version, coreVersions[core])
+		}
+	}
+	return nil
+}
+
+// Pending returns the jobs that were not completed yet, in the planned order.
+func (j *Journal) Pending() []string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	done := make(map[string]bool)
+	for _, lib := range j.Done {
+		done[lib] = true
+	}
+	var pending []string
+	for _, lib := range j.Jobs {
+		if !done[lib] {
+			pending = append(pending, lib)
+		}
+	}
+	return pending
+}
+
+// MarkDone records that a job was completed and saves the journal.
+func (j *Journal) MarkDone(lib string) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.Done = append(j.Done, lib)
+	return j.save()
+}
+
+func (j *Journal) Save() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.save()
+}
+
+func (j *Journal) save() error {
+	jsonData, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(j.path, jsonData, 0644)
+}
+
+// Remove deletes the journal once the run is complete.
+func (j *Journal) Remove() error {
+	return os.Remove(j.path)
+}
@@ -0,0 +1,60 @@
+package journal
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	fqbns := []string{"arduino:avr:uno", "arduino:samd:mkr1000"}
+	cores := map[string]string{"arduino:avr": "1.8.5", "arduino:samd": "1.8.13"}
+	j := New(dir, fqbns, cores, true, []string{"Foo", "Bar", "Baz"})
+	j.Selection = map[string][]string{"Bar": {"arduino:avr:uno"}}
+	if err := j.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.MarkDone("Bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed.StartedAt.Equal(j.StartedAt) || !resumed.Force || !reflect.DeepEqual(resumed.Selection, j.Selection) {
+		t.Errorf("resumed journal = %+v, want %+v", resumed, j)
+	}
+	if got, want := resumed.Pending(), []string{"Foo", "Baz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Pending = %v, want %v", got, want)
+	}
+
+	// The FQBNs can be given in any order
+	if err := resumed.Matches([]string{"arduino:samd:mkr1000", "arduino:avr:uno"}, cores); err != nil {
+		t.Errorf("Matches: %v", err)
+	}
+	if err := resumed.Matches(fqbns[:1], cores); err == nil {
+		t.Errorf("resuming with different FQBNs allowed")
+	}
+	if err := resumed.Matches(fqbns, map[string]string{"arduino:avr": "1.8.6", "arduino:samd": "1.8.13"}); err == nil {
+		t.Errorf("resuming with a different core version allowed")
+	}
+
+	if err := resumed.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); !os.IsNotExist(err) {
+		t.Errorf("Load after Remove: %v", err)
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(Path(dir), []byte(`{"jobs": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); err == nil {
+		t.Errorf("corrupt journal loaded")
+	}
+}
@@ -0,0 +1,150 @@
+package junit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+type property struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type failure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type testcase struct {
+	Name      string   `xml:"name,attr"`
+	Classname string   `xml:"classname,attr"`
+	Time      float64  `xml:"time,attr,omitempty"`
+	Failure   *failure `xml:"failure,omitempty"`
+	SystemOut string   `xml:"system-out,omitempty"`
+}
+
+type testsuite struct {
+	XMLName    xml.Name   `xml:"testsuite"`
+	Name       string     `xml:"name,attr"`
+	Tests      int        `xml:"tests,attr"`
+	Failures   int        `xml:"failures,attr"`
+	Time       float64    `xml:"time,attr"`
+	Timestamp  string     `xml:"timestamp,attr,omitempty"`
+	Properties []property `xml:"properties>property"`
+	Testcases  []testcase `xml:"testcase"`
+}
+
+// Writer writes test results as a JUnit XML file, with a testsuite for each
+// library and FQBN, and a testcase for the inclusion sketch and for each
+// example. Suites are written as they are added, so that the results of
+// long runs don't need to be kept in memory.
+type Writer struct {
+	f   *os.File
+	enc *xml.Encoder
+	mu  sync.Mutex
+}
+
+// Create creates a JUnit XML file.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(f, xml.Header+"<testsuites>\n"); err != nil {
+		f.Close()
+		return nil, err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("  ", "  ")
+	return &Writer{f: f, enc: enc}, nil
+}
+
+// Add writes a testsuite for each of the given tests of a library. It's safe
+// to call from multiple goroutines.
+func (w *Writer) Add(name string, tests []test.TestResult) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, t := range tests {
+		if err := w.enc.Encode(suite(name, t)); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w.f, "\n"); err != nil {
+			return err
+		}
+	}
+	return w.enc.Flush()
+}
+
+// Close completes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := io.WriteString(w.f, "</testsuites>\n"); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+func suite(name string, t test.TestResult) testsuite {
+	suiteName := fmt.Sprintf("%s (%s)", name, t.FQBN)
+	s := testsuite{
+		Name:      suiteName,
+		Time:      t.Duration,
+		Timestamp: timestamp(t.Run),
+		Properties: []property{
+			{"library", name},
+			{"version", t.Version},
+			{"fqbn", t.FQBN},
+			{"core_version", t.CoreVersion},
+			{"status", string(t.Status())},
+		},
+	}
+	add := func(caseName string, result test.CompilationResult, log string) {
+		c := testcase{Name: caseName, Classname: suiteName, SystemOut: log}
+		if result == test.FAIL {
+			c.Failure = &failure{
+				Message: test.FirstError(log),
+				Type:    "compilation",
+				Text:    errorLines(log),
+			}
+			s.Failures++
+		}
+		s.Tests++
+		s.Testcases = append(s.Testcases, c)
+	}
+	add("inclusion", t.Result, t.Log)
+	for _, e := range t.Examples {
+		add(e.Name, e.Result, e.Log)
+	}
+	return s
+}
+
+// timestamp converts the RFC 3339 time of a run to the format of JUnit, which
+// doesn't allow a time zone: the time is in UTC.
+func timestamp(run string) string {
+	t, err := time.Parse(time.RFC3339, run)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02T15:04:05")
+}
+
+// errorLines returns the errors found in a compilation log, one per line.
+func errorLines(log string) string {
+	var lines []string
+	for _, d := range test.ParseDiagnostics(log) {
+		if d.Severity == "error" {
+			lines = append(lines, fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
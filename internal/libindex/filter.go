@@ -0,0 +1,134 @@
+package libindex
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// Filter selects libraries from the index. Each field holds a list of glob
+// patterns: a library matches a field if any of the patterns matches, and
+// it matches the filter if all the non-empty fields match. Names are
+// matched case-sensitively like in testall, metadata case-insensitively.
+type Filter struct {
+	Names         []string
+	Authors       []string
+	Maintainers   []string
+	Categories    []string
+	Types         []string
+	Architectures []string
+}
+
+type compiledFilter struct {
+	names, authors, maintainers, categories, types, architectures []glob.Glob
+}
+
+// IsEmpty returns true if the filter would select all the libraries.
+func (f Filter) IsEmpty() bool {
+	return len(f.Names)+len(f.Authors)+len(f.Maintainers)+len(f.Categories)+len(f.Types)+len(f.Architectures) == 0
+}
+
+// Apply returns the libraries matching the filter.
+func (f Filter) Apply(libraries map[string]Library) (map[string]Library, error) {
+	var c compiledFilter
+	var err error
+	compile := func(patterns []string, lowercase bool) []glob.Glob {
+		var globs []glob.Glob
+		for _, p := range patterns {
+			if lowercase {
+				p = strings.ToLower(p)
+			}
+			g, e := glob.Compile(p)
+			if e != nil && err == nil {
+				err = fmt.Errorf("invalid pattern %s: %v", p, e)
+			}
+			globs = append(globs, g)
+		}
+		return globs
+	}
+	c.names = compile(f.Names, false)
+	c.authors = compile(f.Authors, true)
+	c.maintainers = compile(f.Maintainers, true)
+	c.categories = compile(f.Categories, true)
+	c.types = compile(f.Types, true)
+	c.architectures = compile(f.Architectures, true)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]Library)
+	for name, lib := range libraries {
+		if c.match(lib) {
+			selected[name] = lib
+		}
+	}
+	return selected, nil
+}
+
+func (c compiledFilter) match(lib Library) bool {
+	return matchAny(c.names, []string{lib.Name}, false) &&
+		matchAny(c.authors, []string{lib.Author}, true) &&
+		matchAny(c.maintainers, []string{lib.Maintainer}, true) &&
+		matchAny(c.categories, []string{lib.Category}, true) &&
+		matchAny(c.types, lib.Types, true) &&
+		c.matchArchitectures(lib.Architectures)
+}
+
+// matchArchitectures treats a library declaring architectures=* as
+// compatible with any architecture.
+func (c compiledFilter) matchArchitectures(architectures []string) bool {
+	for _, arch := range architectures {
+		if strings.TrimSpace(arch) == "*" {
+			return true
+		}
+	}
+	return matchAny(c.architectures, architectures, true)
+}
+
+// matchAny returns true if any of the values matches any of the patterns, or
+// if there are no patterns at all.
+func matchAny(globs []glob.Glob, values []string, lowercase bool) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if lowercase {
+			v = strings.ToLower(v)
+		}
+		for _, g := range globs {
+			if g.Match(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithDependencies adds to the selected libraries all the libraries they
+// depend on, recursively. Dependencies are resolved to the latest release
+// available in the index, since only one version of each library can be
+// installed at a time.
+func WithDependencies(selected map[string]Library, all map[string]Library) map[string]Library {
+	result := make(map[string]Library)
+	var queue []Library
+	for name, lib := range selected {
+		result[name] = lib
+		queue = append(queue, lib)
+	}
+	for len(queue) > 0 {
+		lib := queue[0]
+		queue = queue[1:]
+		for _, dep := range lib.Dependencies {
+			if _, ok := result[dep.Name]; ok {
+				continue
+			}
+			if d, ok := all[dep.Name]; ok {
+				result[dep.Name] = d
+				queue = append(queue, d)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+package libindex
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func names(libraries map[string]Library) []string {
+	var names []string
+	for name := range libraries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var libraries = map[string]Library{
+	"Arduino_Foo": {Name: "Arduino_Foo", Author: "Arduino", Maintainer: "Arduino <info@arduino.cc>", Category: "Sensors", Types: []string{"Arduino"}, Architectures: []string{"avr", "samd"}},
+	"Arduino_Bar": {Name: "Arduino_Bar", Author: "Arduino", Category: "Communication", Types: []string{"Arduino"}, Architectures: []string{"*"}, Dependencies: []Dependency{{Name: "Baz"}}},
+	"Baz":         {Name: "Baz", Author: "Jane Doe", Category: "Timing", Types: []string{"Contributed"}, Architectures: []string{"esp32"}, Dependencies: []Dependency{{Name: "Qux"}, {Name: "Missing"}}},
+	"Qux":         {Name: "Qux", Author: "John Doe", Category: "Timing", Types: []string{"Contributed"}, Architectures: []string{" AVR"}, Dependencies: []Dependency{{Name: "Baz"}}},
+}
+
+func TestFilter(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"names", Filter{Names: []string{"Arduino_*"}}, []string{"Arduino_Bar", "Arduino_Foo"}},
+		{"names are case-sensitive", Filter{Names: []string{"arduino_*"}}, nil},
+		{"authors are case-insensitive", Filter{Authors: []string{"* DOE"}}, []string{"Baz", "Qux"}},
+		{"maintainers", Filter{Maintainers: []string{"arduino*"}}, []string{"Arduino_Foo"}},
+		{"any pattern", Filter{Categories: []string{"Sensors", "Timing"}}, []string{"Arduino_Foo", "Baz", "Qux"}},
+		{"all fields", Filter{Names: []string{"Arduino_*"}, Categories: []string{"Timing"}}, nil},
+		{"types", Filter{Types: []string{"contributed"}}, []string{"Baz", "Qux"}},
+		{"architectures", Filter{Architectures: []string{"avr"}}, []string{"Arduino_Bar", "Arduino_Foo", "Qux"}},
+	} {
+		got, err := c.filter.Apply(libraries)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(names(got), c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, names(got), c.want)
+		}
+	}
+
+	if !(Filter{}).IsEmpty() || (Filter{Types: []string{"Arduino"}}).IsEmpty() {
+		t.Errorf("IsEmpty is wrong")
+	}
+	if _, err := (Filter{Names: []string{"[Foo"}}).Apply(libraries); err == nil {
+		t.Errorf("invalid pattern accepted")
+	}
+}
+
+func TestWithDependencies(t *testing.T) {
+	selected := map[string]Library{"Arduino_Bar": libraries["Arduino_Bar"]}
+	// Dependencies are resolved recursively, ignoring cycles and libraries
+	// missing from the index
+	want := []string{"Arduino_Bar", "Baz", "Qux"}
+	if got := WithDependencies(selected, libraries); !reflect.DeepEqual(names(got), want) {
+		t.Errorf("WithDependencies = %v, want %v", names(got), want)
+	}
+	if len(selected) != 1 {
+		t.Errorf("the selection was modified")
+	}
+}
@@ -0,0 +1,161 @@
+package libindex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	semver "go.bug.st/relaxed-semver"
+)
+
+// Library is a single library release as listed in library_index.json.
+// All the fields known to arduino-cli are listed, so that an index can be
+// written back without losing information.
+type Library struct {
+	Name             string       `json:"name"`
+	Version          string       `json:"version"`
+	Author           string       `json:"author"`
+	Maintainer       string       `json:"maintainer"`
+	Sentence         string       `json:"sentence"`
+	Paragraph        string       `json:"paragraph"`
+	Website          string       `json:"website"`
+	Category         string       `json:"category"`
+	Architectures    []string     `json:"architectures"`
+	Types            []string     `json:"types"`
+	URL              string       `json:"url"`
+	ArchiveFileName  string       `json:"archiveFileName"`
+	Size             int64        `json:"size"`
+	Checksum         string       `json:"checksum"`
+	Dependencies     []Dependency `json:"dependencies,omitempty"`
+	License          string       `json:"license"`
+	ProvidesIncludes []string     `json:"providesIncludes"`
+}
+
+type Dependency struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type Index struct {
+	Libraries []Library `json:"libraries"`
+}
+
+// Load reads a library index from a local file.
+func Load(path string) (*Index, error) {
+	byteValue, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parse(path, byteValue)
+}
+
+// LoadSource reads a library index from a http(s):// or file:// URL, or from
+// a local path. Indexes ending in .gz are decompressed.
+func LoadSource(source string) (*Index, error) {
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") {
+		// Not a URL (this includes Windows paths such as C:\index.json)
+		return Load(source)
+	}
+	if u.Scheme == "file" {
+		return Load(u.Path)
+	}
+
+	resp, err := http.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", source, resp.Status)
+	}
+	byteValue, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parse(u.Path, byteValue)
+}
+
+// LoadWithAdditional reads the official index and merges it with the
+// additional sources (see Merge for the precedence rules).
+func LoadWithAdditional(official string, additional []string) (*Index, error) {
+	index, err := LoadSource(official)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []*Index
+	for _, source := range additional {
+		i, err := LoadSource(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		indexes = append(indexes, i)
+	}
+	return Merge(append(indexes, index)...), nil
+}
+
+func parse(name string, byteValue []byte) (*Index, error) {
+	if strings.HasSuffix(name, ".gz") {
+		r, err := gzip.NewReader(bytes.NewReader(byteValue))
+		if err != nil {
+			return nil, err
+		}
+		if byteValue, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+	var index Index
+	if err := json.Unmarshal(byteValue, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// Merge combines several indexes, in decreasing order of precedence. Each
+// library is taken as a whole from the first index listing it: releases of
+// a library with the same name in lower precedence indexes are ignored, so
+// that a library can't mix releases coming from different sources.
+func Merge(indexes ...*Index) *Index {
+	merged := &Index{}
+	seen := make(map[string]bool)
+	for _, index := range indexes {
+		names := make(map[string]bool)
+		for _, lib := range index.Libraries {
+			if seen[lib.Name] {
+				continue
+			}
+			names[lib.Name] = true
+			merged.Libraries = append(merged.Libraries, lib)
+		}
+		for name := range names {
+			seen[name] = true
+		}
+	}
+	return merged
+}
+
+// Save writes the index to a local file.
+func (index *Index) Save(path string) error {
+	return writeJSON(path, index)
+}
+
+// Latest returns the most recent release of each library, indexed by the
+// unsanitized library name.
+func (index *Index) Latest() map[string]Library {
+	libraries := make(map[string]Library)
+	for _, lib := range index.Libraries {
+		if l, ok := libraries[lib.Name]; ok {
+			if semver.ParseRelaxed(lib.Version).GreaterThan(semver.ParseRelaxed(l.Version)) {
+				libraries[lib.Name] = lib
+			}
+		} else {
+			libraries[lib.Name] = lib
+		}
+	}
+	return libraries
+}
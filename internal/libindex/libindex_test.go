@@ -0,0 +1,34 @@
+package libindex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	official := &Index{Libraries: []Library{
+		{Name: "Foo", Version: "1.0.0", URL: "official"},
+		{Name: "Foo", Version: "1.1.0", URL: "official"},
+		{Name: "Bar", Version: "1.0.0", URL: "official"},
+	}}
+	additional := &Index{Libraries: []Library{
+		{Name: "Foo", Version: "0.9.0", URL: "additional"},
+		{Name: "Baz", Version: "2.0.0", URL: "additional"},
+	}}
+
+	// Libraries come as a whole from the first index listing them, even if
+	// other indexes have more recent releases
+	merged := Merge(additional, official)
+	want := []Library{
+		{Name: "Foo", Version: "0.9.0", URL: "additional"},
+		{Name: "Baz", Version: "2.0.0", URL: "additional"},
+		{Name: "Bar", Version: "1.0.0", URL: "official"},
+	}
+	if !reflect.DeepEqual(merged.Libraries, want) {
+		t.Errorf("Merge = %+v, want %+v", merged.Libraries, want)
+	}
+
+	if latest := Merge(official).Latest(); latest["Foo"].Version != "1.1.0" {
+		t.Errorf("latest Foo is %s, want 1.1.0", latest["Foo"].Version)
+	}
+}
@@ -0,0 +1,124 @@
+package libindex
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/alranel/arduino-testlib/internal/util"
+)
+
+// ManifestEntry records a library release installed by installall.
+type ManifestEntry struct {
+	Version     string    `json:"version"`
+	Checksum    string    `json:"checksum"` // empty if unverified
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// Manifest keeps track of the libraries installed by installall so that
+// subsequent runs can be incremental.
+type Manifest struct {
+	SyncedAt  time.Time                `json:"synced_at"`
+	Libraries map[string]ManifestEntry `json:"libraries"` // unsanitized name => entry
+}
+
+// Change describes a library that was added, updated or removed by a sync.
+type Change struct {
+	Name       string `json:"name"`
+	OldVersion string `json:"old_version,omitempty"`
+	NewVersion string `json:"new_version,omitempty"`
+}
+
+// Changelog lists the changes applied by the syncs since the changelog was
+// last consumed.
+type Changelog struct {
+	Since    time.Time `json:"since"` // the first sync
+	SyncedAt time.Time `json:"synced_at"`
+	Added    []Change  `json:"added"`
+	Updated  []Change  `json:"updated"`
+	Removed  []Change  `json:"removed"`
+
+	// Consumed is set once the changes were tested, so that the next sync
+	// starts a new changelog
+	Consumed bool `json:"consumed,omitempty"`
+}
+
+// Add records a change, combining it with the change already recorded for
+// the same library: for instance a library added and then updated is added
+// with its last version, and a library added and then removed is dropped.
+func (c *Changelog) Add(change Change) {
+	for _, list := range []*[]Change{&c.Added, &c.Updated, &c.Removed} {
+		for i, prev := range *list {
+			if prev.Name == change.Name {
+				change.OldVersion = prev.OldVersion
+				*list = append((*list)[:i:i], (*list)[i+1:]...)
+				break
+			}
+		}
+	}
+	switch {
+	case change.OldVersion == change.NewVersion:
+		// Back to the version before the first sync
+	case change.OldVersion == "":
+		c.Added = append(c.Added, change)
+	case change.NewVersion == "":
+		c.Removed = append(c.Removed, change)
+	default:
+		c.Updated = append(c.Updated, change)
+	}
+}
+
+// Changed returns the names of the libraries that were added or updated.
+func (c *Changelog) Changed() []string {
+	var names []string
+	for _, l := range append(c.Added, c.Updated...) {
+		names = append(names, l.Name)
+	}
+	return names
+}
+
+// LoadManifest reads the manifest file. A missing file is not an error and
+// yields an empty manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	manifest := &Manifest{Libraries: make(map[string]ManifestEntry)}
+	if err := readJSON(path, manifest); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if manifest.Libraries == nil {
+		manifest.Libraries = make(map[string]ManifestEntry)
+	}
+	return manifest, nil
+}
+
+func (m *Manifest) Save(path string) error {
+	return writeJSON(path, m)
+}
+
+func LoadChangelog(path string) (*Changelog, error) {
+	changelog := &Changelog{}
+	if err := readJSON(path, changelog); err != nil {
+		return nil, err
+	}
+	return changelog, nil
+}
+
+func (c *Changelog) Save(path string) error {
+	return writeJSON(path, c)
+}
+
+func readJSON(path string, v interface{}) error {
+	byteValue, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(byteValue, v)
+}
+
+func writeJSON(path string, v interface{}) error {
+	jsonData, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, jsonData, 0644)
+}
@@ -0,0 +1,32 @@
+package libindex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangelogAdd(t *testing.T) {
+	var c Changelog
+	c.Add(Change{Name: "Added", NewVersion: "1.0.0"})
+	c.Add(Change{Name: "Added", OldVersion: "1.0.0", NewVersion: "1.1.0"})
+	c.Add(Change{Name: "Updated", OldVersion: "1.0.0", NewVersion: "1.1.0"})
+	c.Add(Change{Name: "Updated", OldVersion: "1.1.0", NewVersion: "1.2.0"})
+	c.Add(Change{Name: "Transient", NewVersion: "1.0.0"})
+	c.Add(Change{Name: "Transient", OldVersion: "1.0.0"})
+	c.Add(Change{Name: "Reverted", OldVersion: "1.0.0", NewVersion: "1.1.0"})
+	c.Add(Change{Name: "Reverted", OldVersion: "1.1.0", NewVersion: "1.0.0"})
+	c.Add(Change{Name: "Removed", OldVersion: "1.0.0", NewVersion: "1.1.0"})
+	c.Add(Change{Name: "Removed", OldVersion: "1.1.0"})
+
+	want := Changelog{
+		Added:   []Change{{Name: "Added", NewVersion: "1.1.0"}},
+		Updated: []Change{{Name: "Updated", OldVersion: "1.0.0", NewVersion: "1.2.0"}},
+		Removed: []Change{{Name: "Removed", OldVersion: "1.0.0"}},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("changelog = %+v, want %+v", c, want)
+	}
+	if got := c.Changed(); !reflect.DeepEqual(got, []string{"Added", "Updated"}) {
+		t.Errorf("Changed = %v", got)
+	}
+}
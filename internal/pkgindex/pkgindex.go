@@ -0,0 +1,266 @@
+package pkgindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/arduino/arduino-cli/arduino/cores"
+	"github.com/arduino/arduino-cli/arduino/resources"
+	semver "go.bug.st/relaxed-semver"
+)
+
+// Index is a Boards Manager package index. It is kept in its generic JSON
+// form so that it can be filtered and rewritten without losing any field.
+type Index map[string]interface{}
+
+type object = map[string]interface{}
+
+// Archive is a platform or tool archive referenced by an index.
+type Archive struct {
+	URL, ArchiveFileName, Checksum string
+	Size                           int64
+}
+
+// Load reads a package index from a local file.
+func Load(path string) (Index, error) {
+	byteValue, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var index Index
+	if err := json.Unmarshal(byteValue, &index); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return index, nil
+}
+
+// Save writes the index to a local file.
+func (index Index) Save(path string) error {
+	jsonData, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, jsonData, 0644)
+}
+
+// Merge combines the packages of several indexes into a single index.
+func Merge(indexes ...Index) Index {
+	var packages []interface{}
+	for _, index := range indexes {
+		for _, pkg := range index.packages() {
+			packages = append(packages, pkg)
+		}
+	}
+	return Index{"packages": packages}
+}
+
+// Select returns a copy of the index containing only the latest release of
+// the given cores (in the packager:architecture form), the tools they depend
+// on and the builtin tools. Tools are limited to the flavour compatible with
+// the running OS unless allHosts is true. The archives referenced by the
+// returned index are listed as well.
+func (index Index) Select(coreIDs []string, allHosts bool) (Index, []Archive, error) {
+	platforms := make(map[string][]interface{}) // packager => platform releases
+	tools := make(map[string][]interface{})     // packager => tool releases
+	var archives []Archive
+
+	// Find the latest release of each core
+	type toolRef struct{ packager, name, version string }
+	var deps []toolRef
+	for _, coreID := range coreIDs {
+		t := strings.SplitN(coreID, ":", 2)
+		if len(t) != 2 {
+			return nil, nil, fmt.Errorf("invalid core: %s", coreID)
+		}
+		var latest object
+		for _, p := range list(index.findPackage(t[0]), "platforms") {
+			if str(p, "architecture") != t[1] {
+				continue
+			}
+			if latest == nil || semver.ParseRelaxed(str(p, "version")).GreaterThan(semver.ParseRelaxed(str(latest, "version"))) {
+				latest = p
+			}
+		}
+		if latest == nil {
+			return nil, nil, fmt.Errorf("core not found in package index: %s", coreID)
+		}
+		platforms[t[0]] = append(platforms[t[0]], latest)
+		archives = append(archives, archiveOf(latest))
+		for _, d := range list(latest, "toolsDependencies") {
+			deps = append(deps, toolRef{str(d, "packager"), str(d, "name"), str(d, "version")})
+		}
+		for _, key := range []string{"discoveryDependencies", "monitorDependencies"} {
+			for _, d := range list(latest, key) {
+				deps = append(deps, toolRef{str(d, "packager"), str(d, "name"), ""})
+			}
+		}
+	}
+	for _, tool := range list(index.findPackage("builtin"), "tools") {
+		deps = append(deps, toolRef{"builtin", str(tool, "name"), ""})
+	}
+
+	// Find the tool releases; an empty version means the latest one
+	seen := make(map[toolRef]bool)
+	for _, dep := range deps {
+		var release object
+		for _, tool := range list(index.findPackage(dep.packager), "tools") {
+			if str(tool, "name") != dep.name {
+				continue
+			}
+			if dep.version == "" {
+				if release == nil || semver.ParseRelaxed(str(tool, "version")).GreaterThan(semver.ParseRelaxed(str(release, "version"))) {
+					release = tool
+				}
+			} else if str(tool, "version") == dep.version {
+				release = tool
+			}
+		}
+		if release == nil {
+			return nil, nil, fmt.Errorf("tool not found in package index: %s:%s@%s", dep.packager, dep.name, dep.version)
+		}
+		ref := toolRef{dep.packager, dep.name, str(release, "version")}
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+
+		systems := list(release, "systems")
+		if !allHosts {
+			systems = compatibleSystems(systems)
+		}
+		copied := make(object)
+		for k, v := range release {
+			copied[k] = v
+		}
+		var s []interface{}
+		for _, system := range systems {
+			s = append(s, system)
+			archives = append(archives, archiveOf(system))
+		}
+		copied["systems"] = s
+		tools[dep.packager] = append(tools[dep.packager], copied)
+	}
+
+	// Build the resulting index, preserving the package metadata
+	var packages []interface{}
+	for _, pkg := range index.packages() {
+		name := str(pkg, "name")
+		if platforms[name] == nil && tools[name] == nil {
+			continue
+		}
+		copied := make(object)
+		for k, v := range pkg {
+			copied[k] = v
+		}
+		copied["platforms"] = emptyIfNil(platforms[name])
+		copied["tools"] = emptyIfNil(tools[name])
+		packages = append(packages, copied)
+	}
+	return Index{"packages": packages}, archives, nil
+}
+
+// RewriteURLs points all the platform and tool archives to baseURL.
+func (index Index) RewriteURLs(baseURL string) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	for _, pkg := range index.packages() {
+		for _, p := range list(pkg, "platforms") {
+			p["url"] = baseURL + "/" + str(p, "archiveFileName")
+		}
+		for _, tool := range list(pkg, "tools") {
+			for _, system := range list(tool, "systems") {
+				system["url"] = baseURL + "/" + str(system, "archiveFileName")
+			}
+		}
+	}
+}
+
+// Archives lists all the platform and tool archives referenced by the index.
+func (index Index) Archives() []Archive {
+	var archives []Archive
+	for _, pkg := range index.packages() {
+		for _, p := range list(pkg, "platforms") {
+			archives = append(archives, archiveOf(p))
+		}
+		for _, tool := range list(pkg, "tools") {
+			for _, system := range list(tool, "systems") {
+				archives = append(archives, archiveOf(system))
+			}
+		}
+	}
+	return archives
+}
+
+func (index Index) packages() []object {
+	return list(index, "packages")
+}
+
+func (index Index) findPackage(name string) object {
+	for _, pkg := range index.packages() {
+		if str(pkg, "name") == name {
+			return pkg
+		}
+	}
+	return nil
+}
+
+// compatibleSystems returns the tool flavour compatible with the running OS,
+// using the same matching rules as arduino-cli.
+func compatibleSystems(systems []object) []object {
+	release := &cores.ToolRelease{}
+	for _, system := range systems {
+		release.Flavors = append(release.Flavors, &cores.Flavor{
+			OS:       str(system, "host"),
+			Resource: &resources.DownloadResource{ArchiveFileName: str(system, "archiveFileName")},
+		})
+	}
+	resource := release.GetCompatibleFlavour()
+	for i, flavor := range release.Flavors {
+		if flavor.Resource == resource {
+			return []object{systems[i]}
+		}
+	}
+	return nil
+}
+
+func archiveOf(o object) Archive {
+	a := Archive{
+		URL:             str(o, "url"),
+		ArchiveFileName: str(o, "archiveFileName"),
+		Checksum:        str(o, "checksum"),
+	}
+	// Sizes are usually stored as strings in package indexes
+	switch size := o["size"].(type) {
+	case string:
+		a.Size, _ = strconv.ParseInt(size, 10, 64)
+	case float64:
+		a.Size = int64(size)
+	}
+	return a
+}
+
+func str(o object, key string) string {
+	s, _ := o[key].(string)
+	return s
+}
+
+func list(o object, key string) []object {
+	items, _ := o[key].([]interface{})
+	var objects []object
+	for _, item := range items {
+		if obj, ok := item.(object); ok {
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+func emptyIfNil(items []interface{}) []interface{} {
+	if items == nil {
+		return []interface{}{}
+	}
+	return items
+}
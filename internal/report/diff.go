@@ -0,0 +1,252 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alranel/arduino-testlib/internal/compare"
+	"github.com/alranel/arduino-testlib/internal/store"
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// diffSide is the result of a changed pair on one side of the diff.
+type diffSide struct {
+	Version     string
+	CoreVersion string
+	Result      test.CompilationResult
+	Log         string
+}
+
+// Excerpt returns the error lines of the log, or its last lines.
+func (s diffSide) Excerpt() string {
+	return excerpt(s.Log)
+}
+
+// diffChange is a library/board pair whose inclusion or example result
+// changed.
+type diffChange struct {
+	compare.Change
+	Base, Head diffSide
+}
+
+func (c diffChange) What() string {
+	if c.Example == "" {
+		return "inclusion"
+	}
+	return "example " + c.Example
+}
+
+func (c diffChange) IsFix() bool {
+	return c.Kind == compare.Fix || c.Kind == compare.ExampleFix
+}
+
+type diffBoard struct {
+	Name               string
+	Regressions, Fixes int
+	Changes            []diffChange
+}
+
+// Net is the number of fixes minus the number of regressions.
+func (b diffBoard) Net() int {
+	return b.Fixes - b.Regressions
+}
+
+type diffData struct {
+	Timestamp          string
+	Base, Head         string
+	Compared           int // pairs tested on both sides
+	OnlyBase, OnlyHead int // pairs tested on one side only
+	Regressions, Fixes int
+	Boards             []diffBoard
+}
+
+// Diff compares the last results of the library/board pairs tested in both
+// base and head, prints the pairs whose inclusion or example result changed
+// and writes them to diff.html in outputDir, with excerpts of the logs of
+// both sides.
+func Diff(base store.Store, head store.Store, basePath string, headPath string, outputDir string) {
+	data, err := diff(base, head)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read test results: %v\n", err)
+		os.Exit(1)
+	}
+	data.Base, data.Head = basePath, headPath
+	printDiff(data)
+
+	os.Mkdir(outputDir, os.ModePerm)
+	file := path.Join(outputDir, "diff.html")
+	if err := writeDiffHTML(data, file); err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating report: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\nReport written to %s\n", file)
+}
+
+func diff(base store.Store, head store.Store) (diffData, error) {
+	data := diffData{Timestamp: time.Now().Format(time.RFC850)}
+	boards := make(map[string]*diffBoard)
+	seen := make(map[string]bool) // lowercase names of the libraries in head
+
+	err := head.Walk(func(h test.TestResults) error {
+		if h.Name == "" {
+			return nil
+		}
+		seen[strings.ToLower(h.Name)] = true
+		b, err := base.Get(h.Name)
+		if err != nil {
+			return err
+		}
+		baseTests, headTests := b.LastTests(), h.LastTests()
+		for fqbn := range headTests {
+			if _, ok := baseTests[fqbn]; ok {
+				data.Compared++
+			} else {
+				data.OnlyHead++
+			}
+		}
+		for fqbn := range baseTests {
+			if _, ok := headTests[fqbn]; !ok {
+				data.OnlyBase++
+			}
+		}
+
+		var changes []compare.Change
+		for _, c := range compare.Compare(b, h, nil) {
+			switch c.Kind {
+			case compare.Regression, compare.Fix:
+			case compare.ExampleRegression, compare.ExampleFix:
+				// Examples added since the base didn't change
+				if findExample(baseTests[c.FQBN], c.Example) == nil {
+					continue
+				}
+			default:
+				continue
+			}
+			changes = append(changes, c)
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+
+		// Logs are only loaded for the libraries that changed
+		if err := store.LoadLogs(base, &b); err != nil {
+			return fmt.Errorf("could not read logs of %s: %v", b.Name, err)
+		}
+		if err := store.LoadLogs(head, &h); err != nil {
+			return fmt.Errorf("could not read logs of %s: %v", h.Name, err)
+		}
+		baseTests, headTests = b.LastTests(), h.LastTests()
+		for _, c := range changes {
+			d := diffChange{
+				Change: c,
+				Base:   side(baseTests[c.FQBN], c.Example),
+				Head:   side(headTests[c.FQBN], c.Example),
+			}
+			board := boards[c.FQBN]
+			if board == nil {
+				board = &diffBoard{Name: c.FQBN}
+				boards[c.FQBN] = board
+			}
+			board.Changes = append(board.Changes, d)
+			if d.IsFix() {
+				board.Fixes++
+				data.Fixes++
+			} else {
+				board.Regressions++
+				data.Regressions++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return data, err
+	}
+
+	// Libraries no longer in head
+	err = base.Walk(func(b test.TestResults) error {
+		if b.Name != "" && !seen[strings.ToLower(b.Name)] {
+			data.OnlyBase += len(b.LastTests())
+		}
+		return nil
+	})
+	if err != nil {
+		return data, err
+	}
+
+	for _, board := range boards {
+		sort.SliceStable(board.Changes, func(i, j int) bool {
+			return strings.ToLower(board.Changes[i].Library) < strings.ToLower(board.Changes[j].Library)
+		})
+		data.Boards = append(data.Boards, *board)
+	}
+	sort.Slice(data.Boards, func(i, j int) bool {
+		return data.Boards[i].Name < data.Boards[j].Name
+	})
+	return data, nil
+}
+
+func findExample(t test.TestResult, name string) *test.ExampleResult {
+	for i := range t.Examples {
+		if t.Examples[i].Name == name {
+			return &t.Examples[i]
+		}
+	}
+	return nil
+}
+
+// side returns the result of the inclusion, or of an example, of a test.
+func side(t test.TestResult, example string) diffSide {
+	s := diffSide{Version: t.Version, CoreVersion: t.CoreVersion, Result: t.Result, Log: t.Log}
+	if example != "" {
+		s.Result, s.Log = "", ""
+		if e := findExample(t, example); e != nil {
+			s.Result, s.Log = e.Result, e.Log
+		}
+	}
+	return s
+}
+
+// printDiff outputs the changes of each board to the console.
+func printDiff(data diffData) {
+	fmt.Printf("Compared %d library/board pairs between %s and %s", data.Compared, data.Base, data.Head)
+	if data.OnlyBase > 0 || data.OnlyHead > 0 {
+		fmt.Printf(" (%d tested only in %s, %d only in %s)", data.OnlyBase, data.Base, data.OnlyHead, data.Head)
+	}
+	fmt.Printf("\n\n")
+	if len(data.Boards) == 0 {
+		fmt.Printf("No changes.\n")
+		return
+	}
+	for _, board := range data.Boards {
+		fmt.Printf("%s: %d regressions, %d fixes (net %+d)\n", board.Name, board.Regressions, board.Fixes, board.Net())
+		for _, c := range board.Changes {
+			fmt.Printf("- %s\n", c.String())
+			failing := c.Head
+			if c.IsFix() {
+				failing = c.Base
+			}
+			if e := test.FirstError(failing.Log); e != "" {
+				fmt.Printf("    %s\n", e)
+			}
+		}
+	}
+	fmt.Printf("\nTotal: %d regressions, %d fixes (net %+d)\n", data.Regressions, data.Fixes, data.Fixes-data.Regressions)
+}
+
+func writeDiffHTML(data diffData, file string) error {
+	templ, err := template.New("report").Parse(htmlTmplDiff)
+	if err != nil {
+		panic(err)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return templ.Execute(f, data)
+}
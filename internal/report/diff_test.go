@@ -0,0 +1,103 @@
+package report
+
+import (
+	"testing"
+
+	"github.com/alranel/arduino-testlib/internal/compare"
+	"github.com/alranel/arduino-testlib/internal/store"
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+func TestDiff(t *testing.T) {
+	const uno, mkr = "arduino:avr:uno", "arduino:samd:mkr1000"
+	cores := map[string]string{uno: "arduino:avr", mkr: "arduino:samd"}
+	result := func(fqbn string, version string, r test.CompilationResult, examples ...test.ExampleResult) test.TestResult {
+		tr := test.TestResult{Version: version, FQBN: fqbn, Core: cores[fqbn], Architectures: []string{"avr", "samd"},
+			Result: r, Examples: examples}
+		if r == test.FAIL {
+			tr.Log = "Foo.cpp:1:1: error: 'x' was not declared in this scope\n"
+		}
+		return tr
+	}
+	pass := func(name string) test.ExampleResult { return test.ExampleResult{Name: name, Result: test.PASS} }
+	fail := func(name string) test.ExampleResult {
+		return test.ExampleResult{Name: name, Result: test.FAIL, Log: name + ".ino:1:1: error: failed\n"}
+	}
+	datadir := func(libraries ...test.TestResults) store.Store {
+		s, err := store.Open("json", t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		for _, tr := range libraries {
+			if err := s.Put(tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return s
+	}
+
+	base := datadir(
+		test.TestResults{Name: "Foo", Tests: []test.TestResult{
+			result(uno, "1.0.0", test.PASS, pass("Blink")),
+			result(mkr, "1.0.0", test.FAIL),
+		}},
+		test.TestResults{Name: "Same", Tests: []test.TestResult{result(uno, "1.0.0", test.FAIL)}},
+		// Only in base, on two boards
+		test.TestResults{Name: "Removed", Tests: []test.TestResult{result(uno, "1.0.0", test.PASS), result(mkr, "1.0.0", test.PASS)}},
+		test.TestResults{Name: "Ported", Tests: []test.TestResult{result(uno, "1.0.0", test.PASS)}},
+	)
+	head := datadir(
+		test.TestResults{Name: "Foo", Tests: []test.TestResult{
+			// The example that passed now fails, while the example added
+			// since the base isn't a change
+			result(uno, "1.1.0", test.PASS, fail("Blink"), fail("Added")),
+			result(mkr, "1.1.0", test.PASS),
+		}},
+		test.TestResults{Name: "Same", Tests: []test.TestResult{result(uno, "1.1.0", test.FAIL)}},
+		// Only in head
+		test.TestResults{Name: "New", Tests: []test.TestResult{result(uno, "1.0.0", test.FAIL)}},
+		// Tested on one more board in head
+		test.TestResults{Name: "Ported", Tests: []test.TestResult{result(uno, "1.0.0", test.PASS), result(mkr, "1.0.0", test.FAIL)}},
+	)
+
+	data, err := diff(base, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Foo on both boards, Same and Ported on the Uno
+	if data.Compared != 4 || data.OnlyBase != 2 || data.OnlyHead != 2 {
+		t.Errorf("compared %d, only in base %d, only in head %d, want 4, 2, 2", data.Compared, data.OnlyBase, data.OnlyHead)
+	}
+	if data.Regressions != 1 || data.Fixes != 1 {
+		t.Errorf("%d regressions, %d fixes, want 1, 1", data.Regressions, data.Fixes)
+	}
+	if len(data.Boards) != 2 || data.Boards[0].Name != uno || data.Boards[1].Name != mkr {
+		t.Fatalf("boards = %+v, want %s and %s", data.Boards, uno, mkr)
+	}
+
+	regressions := data.Boards[0]
+	if len(regressions.Changes) != 1 || regressions.Net() != -1 {
+		t.Fatalf("changes on %s = %+v, want the Blink regression", uno, regressions.Changes)
+	}
+	c := regressions.Changes[0]
+	if c.Kind != compare.ExampleRegression || c.Library != "Foo" || c.Example != "Blink" || c.What() != "example Blink" {
+		t.Errorf("change on %s = %+v, want the Blink regression of Foo", uno, c.Change)
+	}
+	if c.Base.Result != test.PASS || c.Head.Result != test.FAIL || c.Head.Version != "1.1.0" || c.Head.Log == "" {
+		t.Errorf("sides of the regression = %+v, %+v", c.Base, c.Head)
+	}
+
+	fixes := data.Boards[1]
+	if len(fixes.Changes) != 1 || fixes.Net() != 1 {
+		t.Fatalf("changes on %s = %+v, want the Foo fix", mkr, fixes.Changes)
+	}
+	c = fixes.Changes[0]
+	if c.Kind != compare.Fix || c.Library != "Foo" || !c.IsFix() || c.What() != "inclusion" {
+		t.Errorf("change on %s = %+v, want the fix of Foo", mkr, c.Change)
+	}
+	// The log of the failing side is loaded from the store
+	if c.Base.Result != test.FAIL || c.Base.Excerpt() != "Foo.cpp:1:1: error: 'x' was not declared in this scope" {
+		t.Errorf("base side of the fix = %+v", c.Base)
+	}
+}
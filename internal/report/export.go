@@ -0,0 +1,122 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"path"
+	"strconv"
+
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// matrixEntry is the last result of a library/board pair.
+type matrixEntry struct {
+	Library        string                   `json:"library"`
+	Version        string                   `json:"version"`
+	Board          string                   `json:"board"`
+	CoreVersion    string                   `json:"core_version"`
+	Status         test.CompatibilityStatus `json:"status"`
+	Result         test.CompilationResult   `json:"result"`
+	Examples       int                      `json:"examples"`
+	ExamplesPassed int                      `json:"examples_passed"`
+}
+
+// matrix returns the library/board pairs sorted by library and board.
+func matrix(reportData aggregates) []matrixEntry {
+	var entries []matrixEntry
+	for _, lib := range reportData.Libraries {
+		for _, board := range reportData.Boards {
+			t, ok := lib.BoardTestResults[board.Name]
+			if !ok {
+				continue
+			}
+			e := matrixEntry{
+				Library:     lib.Name,
+				Version:     t.Version,
+				Board:       board.Name,
+				CoreVersion: t.CoreVersion,
+				Status:      lib.BoardCompatibility[board.Name],
+				Result:      t.Result,
+				Examples:    len(t.Examples),
+			}
+			for _, ex := range t.Examples {
+				if ex.Result == test.PASS {
+					e.ExamplesPassed++
+				}
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// writeJSON writes the board summary, the examples distribution and the
+// library/board matrix to report.json.
+func writeJSON(reportData aggregates, outputDir string) ([]string, error) {
+	data := struct {
+		Timestamp    string              `json:"timestamp"`
+		NumLibs      int                 `json:"num_libraries"`
+		NumAsterisk  int                 `json:"num_libraries_asterisk"`
+		NumFailClaim int                 `json:"num_libraries_fail_claim"`
+		Cores        []coreReportData    `json:"cores"`
+		Boards       []boardReportData   `json:"boards"`
+		Examples     []exampleReportData `json:"examples"`
+		Matrix       []matrixEntry       `json:"matrix"`
+	}{
+		Timestamp:    reportData.Timestamp,
+		NumLibs:      reportData.NumLibs,
+		NumAsterisk:  reportData.NumLibsCompatibilityAsterisk,
+		NumFailClaim: reportData.NumLibsFailClaim,
+		Cores:        reportData.Cores,
+		Boards:       reportData.Boards,
+		Examples:     reportData.Examples,
+		Matrix:       matrix(reportData),
+	}
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	file := path.Join(outputDir, "report.json")
+	return []string{file}, util.WriteFileAtomic(file, b, 0644)
+}
+
+// writeCSV writes the library/board matrix to matrix.csv and the board
+// summary to boards.csv.
+func writeCSV(reportData aggregates, outputDir string) ([]string, error) {
+	matrixRows := [][]string{{"library", "version", "board", "core_version", "status", "result", "examples", "examples_passed"}}
+	for _, e := range matrix(reportData) {
+		matrixRows = append(matrixRows, []string{
+			e.Library, e.Version, e.Board, e.CoreVersion, string(e.Status), string(e.Result),
+			strconv.Itoa(e.Examples), strconv.Itoa(e.ExamplesPassed),
+		})
+	}
+	boardRows := [][]string{{"board", "architecture", "versions", "claim", "explicit_claim", "claim_mismatch",
+		"pass", "fail", "untested", "pass_claim", "pass_noclaim", "fail_claim", "fail_claim_asterisk", "fail_explicit_claim"}}
+	for _, b := range reportData.Boards {
+		row := []string{b.Name, b.Architecture, b.Versions}
+		for _, n := range []int{b.Claim, b.ExplicitClaim, b.ClaimMismatch, b.Pass, b.Fail, b.Untested,
+			b.PassClaim, b.PassNoClaim, b.FailClaim, b.FailClaimAsterisk, b.FailExplicitClaim} {
+			row = append(row, strconv.Itoa(n))
+		}
+		boardRows = append(boardRows, row)
+	}
+
+	var files []string
+	for _, out := range []struct {
+		name string
+		rows [][]string
+	}{{"matrix.csv", matrixRows}, {"boards.csv", boardRows}} {
+		var buf bytes.Buffer
+		if err := csv.NewWriter(&buf).WriteAll(out.rows); err != nil {
+			return nil, err
+		}
+		file := path.Join(outputDir, out.name)
+		if err := util.WriteFileAtomic(file, buf.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// exportData has a library whose name needs quoting in CSV, and a board on
+// which one of the libraries wasn't tested.
+func exportData() aggregates {
+	const uno, esp32 = "arduino:avr:uno", "esp32:esp32:esp32"
+	return aggregates{
+		Timestamp:        "2022-05-02T10:00:00Z",
+		NumLibs:          2,
+		NumLibsFailClaim: 1,
+		Cores:            []coreReportData{{Architecture: "avr", Claim: 2}, {Architecture: "esp32", Claim: 1}},
+		Boards: []boardReportData{
+			{Name: uno, Architecture: "avr", Versions: "1.8.5", Claim: 2, Pass: 1, Fail: 1, PassClaim: 1, FailClaim: 1},
+			{Name: esp32, Architecture: "esp32", Versions: "2.0.3", Claim: 1, Pass: 1, Untested: 1, PassClaim: 1},
+		},
+		Examples: []exampleReportData{{Num: 1, Count: 1}, {Num: 2, Count: 1}},
+		Libraries: []libraryReportData{
+			{
+				Name:               `Foo, "the" library`,
+				BoardCompatibility: map[string]test.CompatibilityStatus{uno: test.FAIL_CLAIM, esp32: test.PASS_CLAIM},
+				BoardTestResults: map[string]test.TestResult{
+					uno: {Version: "1.0.0", CoreVersion: "1.8.5", Result: test.FAIL,
+						Examples: []test.ExampleResult{{Name: "A", Result: test.FAIL}, {Name: "B", Result: test.PASS}}},
+					esp32: {Version: "1.0.0", CoreVersion: "2.0.3", Result: test.PASS,
+						Examples: []test.ExampleResult{{Name: "A", Result: test.PASS}, {Name: "B", Result: test.PASS}}},
+				},
+			},
+			{
+				Name:               "Bar",
+				BoardCompatibility: map[string]test.CompatibilityStatus{uno: test.PASS_CLAIM},
+				BoardTestResults: map[string]test.TestResult{
+					uno: {Version: "2.1.0", CoreVersion: "1.8.5", Result: test.PASS,
+						Examples: []test.ExampleResult{{Name: "A", Result: test.PASS}}},
+				},
+			},
+		},
+	}
+}
+
+// checkGolden compares the written files with the ones in testdata.
+func checkGolden(t *testing.T, files []string) {
+	for _, file := range files {
+		got, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := ioutil.ReadFile(filepath.Join("testdata", filepath.Base(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s differs from testdata:\n%s\nwant:\n%s", filepath.Base(file), got, want)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	files, err := writeJSON(exportData(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("writeJSON wrote %v, want report.json", files)
+	}
+	checkGolden(t, files)
+}
+
+func TestWriteCSV(t *testing.T) {
+	files, err := writeCSV(exportData(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("writeCSV wrote %v, want matrix.csv and boards.csv", files)
+	}
+	checkGolden(t, files)
+}
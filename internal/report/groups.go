@@ -0,0 +1,211 @@
+package report
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/alranel/arduino-testlib/pkg/test"
+	"github.com/arduino/arduino-cli/arduino/utils"
+)
+
+// uncategorized is the category of the libraries not declaring a valid one,
+// as in the Library Manager.
+const uncategorized = "Uncategorized"
+
+// categories are the valid values of the category field of library.properties.
+var categories = []string{
+	"Display",
+	"Communication",
+	"Signal Input/Output",
+	"Sensors",
+	"Device Control",
+	"Timing",
+	"Data Storage",
+	"Data Processing",
+	"Other",
+	uncategorized,
+}
+
+// emailRegexp matches the email addresses in the maintainer and author fields.
+var emailRegexp = regexp.MustCompile(`\s*<[^>]*>`)
+
+// people returns the names listed in a maintainer or author field, which may
+// list several comma-separated people with their email addresses.
+func people(field string) []string {
+	var names []string
+	for _, name := range strings.Split(emailRegexp.ReplaceAllString(field, ""), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// category returns the category of a library, as shown by the Library
+// Manager.
+func category(declared string) string {
+	declared = strings.TrimSpace(declared)
+	for _, c := range categories {
+		if strings.EqualFold(c, declared) {
+			return c
+		}
+	}
+	return uncategorized
+}
+
+// uniqueFile returns a file name based on name which is not used yet,
+// ignoring case since file systems may do so.
+func uniqueFile(prefix string, name string, used map[string]bool) string {
+	base := prefix + utils.SanitizeName(name)
+	file := base + ".html"
+	for i := 2; used[strings.ToLower(file)]; i++ {
+		file = fmt.Sprintf("%s-%d.html", base, i)
+	}
+	used[strings.ToLower(file)] = true
+	return file
+}
+
+// maintainerIssue is a library/board pair whose result doesn't match the
+// declared compatibility.
+type maintainerIssue struct {
+	Library, Version, ReportFile string
+	Board, Architecture          string
+	Status                       test.CompatibilityStatus
+}
+
+type maintainerReportData struct {
+	Name, ReportFile string
+	Libraries        []libraryReportData
+	Issues           []maintainerIssue
+	FailClaim        int // pairs failing on a declared architecture
+	PassNoClaim      int // pairs compiling on an undeclared architecture
+}
+
+type categoryBoard struct {
+	Name                    string
+	Tested, Pass, FailClaim int
+}
+
+func (b categoryBoard) PassRate() string {
+	if b.Tested == 0 {
+		return "-"
+	}
+	return formatRate(float64(b.Pass) / float64(b.Tested))
+}
+
+func (b categoryBoard) FailClaimRate() string {
+	if b.Tested == 0 {
+		return "-"
+	}
+	return formatRate(float64(b.FailClaim) / float64(b.Tested))
+}
+
+type categoryReportData struct {
+	Name, ReportFile string
+	Libraries        []libraryReportData
+	Boards           []categoryBoard // in the order of the report boards
+}
+
+// groupLibraries groups the libraries by maintainer and by category, using
+// the metadata of their last tested version. It also returns the number of
+// libraries whose metadata is unknown, which are not grouped.
+func groupLibraries(libraries []libraryReportData, boards []boardReportData) ([]maintainerReportData, []categoryReportData, int) {
+	maintainers := make(map[string]*maintainerReportData) // lowercase name => data
+	categoryData := make(map[string]*categoryReportData)
+	noMetadata := 0
+	for _, lib := range libraries {
+		if lib.Metadata.Layout == "" {
+			noMetadata++
+			continue
+		}
+
+		// Libraries without a maintainer are attributed to their authors
+		names := people(lib.Metadata.Maintainer)
+		if len(names) == 0 {
+			names = people(lib.Metadata.Author)
+		}
+		for _, name := range names {
+			m := maintainers[strings.ToLower(name)]
+			if m == nil {
+				m = &maintainerReportData{Name: name}
+				maintainers[strings.ToLower(name)] = m
+			}
+			m.Libraries = append(m.Libraries, lib)
+			for _, board := range boards {
+				status := lib.BoardCompatibility[board.Name]
+				switch status {
+				case test.FAIL_CLAIM:
+					m.FailClaim++
+				case test.PASS_NOCLAIM:
+					m.PassNoClaim++
+				default:
+					continue
+				}
+				m.Issues = append(m.Issues, maintainerIssue{
+					Library:      lib.Name,
+					Version:      lib.Version,
+					ReportFile:   lib.ReportFile,
+					Board:        board.Name,
+					Architecture: util.ArchitectureFromFQBN(board.Name),
+					Status:       status,
+				})
+			}
+		}
+
+		categoryName := category(lib.Metadata.Category)
+		c := categoryData[categoryName]
+		if c == nil {
+			c = &categoryReportData{Name: categoryName}
+			for _, board := range boards {
+				c.Boards = append(c.Boards, categoryBoard{Name: board.Name})
+			}
+			categoryData[categoryName] = c
+		}
+		c.Libraries = append(c.Libraries, lib)
+		for i := range c.Boards {
+			status := lib.BoardCompatibility[c.Boards[i].Name]
+			if status == "" {
+				continue
+			}
+			c.Boards[i].Tested++
+			if status == test.PASS_CLAIM || status == test.PASS_NOCLAIM {
+				c.Boards[i].Pass++
+			}
+			if status == test.FAIL_CLAIM {
+				c.Boards[i].FailClaim++
+			}
+		}
+	}
+
+	// Maintainers with the most issues first. File names are assigned in
+	// the order of the names, so that they don't depend on the issues
+	var maintainerList []maintainerReportData
+	for _, m := range maintainers {
+		maintainerList = append(maintainerList, *m)
+	}
+	sort.Slice(maintainerList, func(i, j int) bool {
+		return strings.ToLower(maintainerList[i].Name) < strings.ToLower(maintainerList[j].Name)
+	})
+	files := make(map[string]bool)
+	for i := range maintainerList {
+		maintainerList[i].ReportFile = uniqueFile("maintainer-", maintainerList[i].Name, files)
+	}
+	sort.SliceStable(maintainerList, func(i, j int) bool {
+		a, b := maintainerList[i], maintainerList[j]
+		return a.FailClaim+a.PassNoClaim > b.FailClaim+b.PassNoClaim
+	})
+	var categoryList []categoryReportData
+	for _, c := range categoryData {
+		categoryList = append(categoryList, *c)
+	}
+	sort.Slice(categoryList, func(i, j int) bool {
+		return categoryList[i].Name < categoryList[j].Name
+	})
+	for i := range categoryList {
+		categoryList[i].ReportFile = uniqueFile("category-", categoryList[i].Name, files)
+	}
+	return maintainerList, categoryList, noMetadata
+}
@@ -0,0 +1,71 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+func TestPeople(t *testing.T) {
+	for field, want := range map[string][]string{
+		"":                                   nil,
+		"Jane Doe <jane@example.com>":        {"Jane Doe"},
+		"Jane Doe <jane@example.com>, John":  {"Jane Doe", "John"},
+		" Arduino <info@arduino.cc> ,, Bob ": {"Arduino", "Bob"},
+	} {
+		if got := people(field); !reflect.DeepEqual(got, want) {
+			t.Errorf("people(%q) = %q, want %q", field, got, want)
+		}
+	}
+}
+
+func TestGroupLibraries(t *testing.T) {
+	boards := []boardReportData{{Name: "arduino:avr:uno"}}
+	lib := func(name, maintainer, author, category string, status test.CompatibilityStatus) libraryReportData {
+		return libraryReportData{
+			Name:               name,
+			BoardCompatibility: map[string]test.CompatibilityStatus{"arduino:avr:uno": status},
+			Metadata:           test.Metadata{Maintainer: maintainer, Author: author, Category: category, Layout: test.LayoutSrc},
+		}
+	}
+	libraries := []libraryReportData{
+		lib("A", "Jane Doe <jane@example.com>, John <john@example.com>", "", "Sensors", test.FAIL_CLAIM),
+		lib("B", "jane doe", "", "sensors", test.PASS_CLAIM),
+		lib("C", "", "Jane_Doe", "Toys", test.PASS_NOCLAIM),
+		{Name: "D"},
+	}
+	maintainers, categories, noMetadata := groupLibraries(libraries, boards)
+	if noMetadata != 1 {
+		t.Errorf("noMetadata = %d, want 1", noMetadata)
+	}
+
+	got := make(map[string][]string) // file => libraries
+	for _, m := range maintainers {
+		for _, l := range m.Libraries {
+			got[m.ReportFile] = append(got[m.ReportFile], l.Name)
+		}
+	}
+	want := map[string][]string{
+		"maintainer-Jane_Doe.html":   {"A", "B"},
+		"maintainer-Jane_Doe-2.html": {"C"},
+		"maintainer-John.html":       {"A"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("maintainers = %v, want %v", got, want)
+	}
+	if maintainers[0].FailClaim != 1 {
+		t.Errorf("the maintainers with the most issues must come first: %+v", maintainers[0])
+	}
+
+	got = make(map[string][]string)
+	for _, c := range categories {
+		for _, l := range c.Libraries {
+			got[c.Name] = append(got[c.Name], l.Name)
+		}
+	}
+	want = map[string][]string{"Sensors": {"A", "B"}, "Uncategorized": {"C"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("categories = %v, want %v", got, want)
+	}
+}
@@ -185,6 +185,33 @@ var htmlTmpl = `
 					</div>
 				</div>
 
+				{{ if .Categories }}
+				<h2>Categories</h2>
+				<p>
+					Pass rate per board of the libraries in each category, according to the metadata of their last tested version.
+					See also the <a href="maintainers.html">compatibility issues by maintainer</a>.
+					{{ if .NumLibsNoMetadata }}{{ .NumLibsNoMetadata }} libraries were tested by an older version of the tool and lack the metadata.{{ end }}
+				</p>
+				<table class="table table-bordered table-sm">
+					<tr>
+						<th>Category</th>
+						<th>Libraries</th>
+						{{ range .Boards }}
+						<th><p style="writing-mode: vertical-rl">{{ .Name }}</p></th>
+						{{ end }}
+					</tr>
+					{{ range .Categories }}
+					<tr>
+						<td><a href="{{ .ReportFile }}"><b>{{ .Name }}</b></a></td>
+						<td>{{ len .Libraries }}</td>
+						{{ range .Boards }}
+						<td>{{ .PassRate }}</td>
+						{{ end }}
+					</tr>
+					{{ end }}
+				</table>
+				{{ end }}
+
 				<h2>Libraries</h2>
 				<table class="table table-bordered table-sm">
 					<tr>
@@ -211,6 +238,12 @@ var htmlTmpl = `
 					{{ end }}
 				</table>
 
+				{{ if .Trends.NumRuns }}
+				<h2>Trends</h2>
+				<p>Pass rate per board over the last {{ .Trends.NumRuns }} runs. See the <a href="trends.html">trends page</a> for the FAIL_CLAIM rate and the libraries that changed state.</p>
+				{{ .Trends.PassRateChart }}
+				{{ end }}
+
 				<h2>Misc stats</h2>
 				<h3>Examples</h3>
 				<p>The following table shows how many examples are provided per library.</p>
@@ -349,3 +382,386 @@ var htmlTmplLibrary = `
   </body>
 </html>
 `
+
+var htmlTmplTrends = `
+<!doctype html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.0.2/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-EVSTQN3/azprG1Anm3QDgpJLIm9Nao0Yz1ztcQTwFspd3yD65VohhpuuCOmLASjC" crossorigin="anonymous">
+    <title>arduino-testlib report</title>
+	<style>
+	.pass { background-color: #00FF00 !important; }
+	.fail { background-color: #FF0000 !important; }
+	</style>
+  </head>
+  <body>
+	<div class="container">
+		<div class="row">
+			<div class="col">
+				<h1>Trends</h1>
+				<p>
+					<small>This report was generated on {{ .Timestamp }} using 
+					<a href="https://github.com/alranel/arduino-testlib">arduino-testlib</a>.
+					<a href="index.html">Back to the report</a>.</small>
+				</p>
+				<p>
+					The rates of each run count the last result of every library tested in that run or before.
+					{{ if .Trends.NumUntimed }}{{ .Trends.NumUntimed }} results recorded without a run are not included.{{ end }}
+				</p>
+
+				<h2>Pass rate</h2>
+				{{ .Trends.PassRateChart }}
+
+				<h2>FAIL_CLAIM rate</h2>
+				<p>Libraries not compiling on boards they declare to be compatible with.</p>
+				{{ .Trends.FailClaimRateChart }}
+
+				<h2>Libraries tested per run</h2>
+				{{ .Trends.LibrariesChart }}
+
+				<h2>Runs</h2>
+				<p>Pass rate per board; hover for the FAIL_CLAIM rate.</p>
+				<table class="table table-bordered table-sm">
+					<tr>
+						<th>Run</th>
+						<th>Libraries</th>
+						{{ range .Boards }}
+						<th><p style="writing-mode: vertical-rl">{{ .Name }}</p></th>
+						{{ end }}
+					</tr>
+					{{ range $run := .Trends.Runs }}
+					<tr>
+						<td>{{ $run.Run }}</td>
+						<td>{{ $run.Libraries }}</td>
+						{{ range $i, $rate := $run.PassRates }}
+						<td title="FAIL_CLAIM: {{ index $run.FailClaimRates $i }}">{{ $rate }}</td>
+						{{ end }}
+					</tr>
+					{{ end }}
+				</table>
+
+				<h2>State changes</h2>
+				{{ range .Trends.Runs }}
+				{{ if .NumFlips }}
+				<h3>{{ .Run }}</h3>
+				<table class="table table-bordered table-sm">
+					<tr>
+						<th>Library</th>
+						<th>Board</th>
+						<th>Before</th>
+						<th>After</th>
+					</tr>
+					{{ range .Flips }}
+					<tr>
+						<td>{{ .Library }}</td>
+						<td>{{ .Board }}</td>
+						<td>{{ .Before }}</td>
+						<td class="{{ if eq .After "FAIL_CLAIM" }}fail{{ else if eq .After "PASS_CLAIM" }}pass{{ end }}">{{ .After }}</td>
+					</tr>
+					{{ end }}
+				</table>
+				{{ if .MoreFlips }}<p>{{ .MoreFlips }} more changes are not listed.</p>{{ end }}
+				{{ end }}
+				{{ end }}
+			</div>
+		</div>
+	</div>
+  </body>
+</html>
+`
+
+var htmlTmplDiff = `
+<!doctype html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.0.2/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-EVSTQN3/azprG1Anm3QDgpJLIm9Nao0Yz1ztcQTwFspd3yD65VohhpuuCOmLASjC" crossorigin="anonymous">
+    <title>arduino-testlib report</title>
+	<style>
+	.pass { background-color: #00FF00 !important; }
+	.fail { background-color: #FF0000 !important; }
+	pre { white-space: pre-wrap; word-break: break-all; }
+	</style>
+  </head>
+  <body>
+	<div class="container">
+		<div class="row">
+			<div class="col">
+				<h1>Changes from {{ .Base }} to {{ .Head }}</h1>
+				<p>
+					<small>This report was generated on {{ .Timestamp }} using 
+					<a href="https://github.com/alranel/arduino-testlib">arduino-testlib</a>.</small>
+				</p>
+
+				<h2>Overview</h2>
+				<p>
+					<b>{{ .Compared }}</b> library/board pairs were tested on both sides:
+					<b>{{ .Regressions }}</b> regressions and <b>{{ .Fixes }}</b> fixes.
+					{{ if or .OnlyBase .OnlyHead }}{{ .OnlyBase }} pairs were only tested in {{ .Base }}, {{ .OnlyHead }} only in {{ .Head }}.{{ end }}
+				</p>
+				<table class="table table-bordered">
+					<tr>
+						<th>Board</th>
+						<th>Regressions</th>
+						<th>Fixes</th>
+						<th>Net change</th>
+					</tr>
+					{{ range .Boards }}
+					<tr>
+						<td><a href="#{{ .Name }}">{{ .Name }}</a></td>
+						<td>{{ .Regressions }}</td>
+						<td>{{ .Fixes }}</td>
+						<td class="{{ if lt .Net 0 }}fail{{ else if gt .Net 0 }}pass{{ end }}">{{ printf "%+d" .Net }}</td>
+					</tr>
+					{{ end }}
+				</table>
+
+				{{ range $board := .Boards }}
+				<h2 id="{{ $board.Name }}">{{ $board.Name }}</h2>
+				<table class="table table-bordered table-sm">
+					<tr>
+						<th>Library</th>
+						<th>Compilation</th>
+						<th>{{ $.Base }}</th>
+						<th>{{ $.Head }}</th>
+					</tr>
+					{{ range $board.Changes }}
+					<tr>
+						<td><b>{{ .Library }}</b></td>
+						<td>{{ .What }}</td>
+						<td class="{{ if .IsFix }}fail{{ else }}pass{{ end }}">{{ .Base.Result }}<br><small>{{ .Base.Version }}, core {{ .Base.CoreVersion }}</small></td>
+						<td class="{{ if .IsFix }}pass{{ else }}fail{{ end }}">{{ .Head.Result }}<br><small>{{ .Head.Version }}, core {{ .Head.CoreVersion }}</small></td>
+					</tr>
+					<tr>
+						<td colspan="2"></td>
+						<td><pre class="pre-scrollable"><small>{{ .Base.Excerpt }}</small></pre></td>
+						<td><pre class="pre-scrollable"><small>{{ .Head.Excerpt }}</small></pre></td>
+					</tr>
+					{{ end }}
+				</table>
+				{{ end }}
+			</div>
+		</div>
+	</div>
+  </body>
+</html>
+`
+
+var htmlTmplMaintainers = `
+<!doctype html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.0.2/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-EVSTQN3/azprG1Anm3QDgpJLIm9Nao0Yz1ztcQTwFspd3yD65VohhpuuCOmLASjC" crossorigin="anonymous">
+    <title>arduino-testlib report</title>
+	<style>
+	.pass { background-color: #00FF00 !important; }
+	.fail { background-color: #FF0000 !important; }
+	</style>
+  </head>
+  <body>
+	<div class="container">
+		<div class="row">
+			<div class="col">
+				<h1>Compatibility issues by maintainer</h1>
+				<p>
+					<small>This report was generated on {{ .Timestamp }} using 
+					<a href="https://github.com/alranel/arduino-testlib">arduino-testlib</a>.
+					<a href="index.html">Back to the report</a>.</small>
+				</p>
+				<p>
+					For each maintainer (or author, when no maintainer is declared), the number of boards their libraries don't compile on
+					despite declaring compatibility (FAIL_CLAIM), and the number of boards they compile on without declaring compatibility.
+				</p>
+				<table class="table table-bordered table-sm">
+					<tr>
+						<th>Maintainer</th>
+						<th>Libraries</th>
+						<th>FAIL_CLAIM</th>
+						<th>Undeclared but compiling</th>
+					</tr>
+					{{ range .Maintainers }}
+					<tr>
+						<td><a href="{{ .ReportFile }}">{{ .Name }}</a></td>
+						<td>{{ len .Libraries }}</td>
+						<td class="{{ if .FailClaim }}fail{{ end }}">{{ .FailClaim }}</td>
+						<td>{{ .PassNoClaim }}</td>
+					</tr>
+					{{ end }}
+				</table>
+			</div>
+		</div>
+	</div>
+  </body>
+</html>
+`
+
+var htmlTmplMaintainer = `
+<!doctype html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.0.2/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-EVSTQN3/azprG1Anm3QDgpJLIm9Nao0Yz1ztcQTwFspd3yD65VohhpuuCOmLASjC" crossorigin="anonymous">
+    <title>arduino-testlib report</title>
+	<style>
+	.pass { background-color: #00FF00 !important; }
+	.fail { background-color: #FF0000 !important; }
+	</style>
+  </head>
+  <body>
+	<div class="container">
+		<div class="row">
+			<div class="col">
+				<h1>{{ .Maintainer.Name }} - compatibility issues</h1>
+				<p>
+					<small>This report was generated on {{ .Timestamp }} using 
+					<a href="https://github.com/alranel/arduino-testlib">arduino-testlib</a>.
+					<a href="index.html">Back to the report</a>.</small>
+				</p>
+				<h2>Libraries</h2>
+				<table class="table table-bordered table-sm">
+					<tr>
+						<th>Library</th>
+						<th>Version</th>
+						<th>Category</th>
+						<th>Description</th>
+					</tr>
+					{{ range .Maintainer.Libraries }}
+					<tr>
+						<td><a href="{{ .ReportFile }}"><b>{{ .Name }}</b></a></td>
+						<td>{{ .Version }}</td>
+						<td>{{ .Metadata.Category }}</td>
+						<td>{{ .Metadata.Sentence }}</td>
+					</tr>
+					{{ end }}
+				</table>
+
+				<h2>Not compiling on declared architectures (FAIL_CLAIM)</h2>
+				{{ if .Maintainer.FailClaim }}
+				<p>These libraries declare compatibility with the architecture of these boards, but don't compile on them.</p>
+				<table class="table table-bordered table-sm">
+					<tr>
+						<th>Library</th>
+						<th>Version</th>
+						<th>Board</th>
+						<th>Architecture</th>
+					</tr>
+					{{ range .Maintainer.Issues }}{{ if eq .Status "FAIL_CLAIM" }}
+					<tr>
+						<td><a href="{{ .ReportFile }}#{{ .Board }}"><b>{{ .Library }}</b></a></td>
+						<td>{{ .Version }}</td>
+						<td class="fail">{{ .Board }}</td>
+						<td>{{ .Architecture }}</td>
+					</tr>
+					{{ end }}{{ end }}
+				</table>
+				{{ else }}
+				<p>None.</p>
+				{{ end }}
+
+				<h2>Compiling on undeclared architectures</h2>
+				{{ if .Maintainer.PassNoClaim }}
+				<p>These libraries compile on these boards, but don't declare compatibility with their architecture in <code>library.properties</code>.</p>
+				<table class="table table-bordered table-sm">
+					<tr>
+						<th>Library</th>
+						<th>Version</th>
+						<th>Board</th>
+						<th>Architecture</th>
+					</tr>
+					{{ range .Maintainer.Issues }}{{ if eq .Status "PASS_NOCLAIM" }}
+					<tr>
+						<td><a href="{{ .ReportFile }}#{{ .Board }}"><b>{{ .Library }}</b></a></td>
+						<td>{{ .Version }}</td>
+						<td>{{ .Board }}</td>
+						<td>{{ .Architecture }}</td>
+					</tr>
+					{{ end }}{{ end }}
+				</table>
+				{{ else }}
+				<p>None.</p>
+				{{ end }}
+			</div>
+		</div>
+	</div>
+  </body>
+</html>
+`
+
+var htmlTmplCategory = `
+<!doctype html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.0.2/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-EVSTQN3/azprG1Anm3QDgpJLIm9Nao0Yz1ztcQTwFspd3yD65VohhpuuCOmLASjC" crossorigin="anonymous">
+    <title>arduino-testlib report</title>
+	<style>
+	.pass { background-color: #00FF00 !important; }
+	.fail { background-color: #FF0000 !important; }
+	</style>
+  </head>
+  <body>
+	<div class="container">
+		<div class="row">
+			<div class="col">
+				<h1>{{ .Category.Name }} - compatibility matrix</h1>
+				<p>
+					<small>This report was generated on {{ .Timestamp }} using 
+					<a href="https://github.com/alranel/arduino-testlib">arduino-testlib</a>.
+					<a href="index.html">Back to the report</a>.</small>
+				</p>
+				<h2>Boards</h2>
+				<table class="table table-bordered">
+					<tr>
+						<th>Board</th>
+						<th>Tested libraries</th>
+						<th>Pass rate</th>
+						<th>FAIL_CLAIM rate</th>
+					</tr>
+					{{ range .Category.Boards }}
+					<tr>
+						<td>{{ .Name }}</td>
+						<td>{{ .Tested }}</td>
+						<td>{{ .PassRate }}</td>
+						<td>{{ .FailClaimRate }}</td>
+					</tr>
+					{{ end }}
+				</table>
+
+				<h2>Libraries</h2>
+				<table class="table table-bordered table-sm">
+					<tr>
+						<th>Library</th>
+						<th>Version</th>
+						{{ range .Boards }}
+						<th><p style="writing-mode: vertical-rl">{{ .Name }}</p></th>
+						{{ end }}
+					</tr>
+					{{ range $lib := .Category.Libraries }}
+					<tr>
+						<td>
+							<a href="{{ $lib.ReportFile }}"><b>{{ $lib.Name }}</b></a>
+						</td>
+						<td>{{ .Version }}</td>
+						{{ range $board := $.Boards }}
+						{{ if eq (index $lib.BoardCompatibility $board.Name) "PASS_CLAIM" }}<td class="pass">PASS</td>{{ end }}
+						{{ if eq (index $lib.BoardCompatibility $board.Name) "PASS_NOCLAIM" }}<td class=""></td>{{ end }}
+						{{ if eq (index $lib.BoardCompatibility $board.Name) "FAIL_CLAIM" }}<td class="fail">FAIL</td>{{ end }}
+						{{ if eq (index $lib.BoardCompatibility $board.Name) "FAIL_NOCLAIM" }}<td class=""></td>{{ end }}
+						{{ if eq (index $lib.BoardCompatibility $board.Name) "" }}<td></td>{{ end }}
+						{{ end }}
+					</tr>
+					{{ end }}
+				</table>
+			</div>
+		</div>
+	</div>
+  </body>
+</html>
+`
@@ -0,0 +1,284 @@
+package report
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alranel/arduino-testlib/internal/compare"
+	"github.com/alranel/arduino-testlib/internal/store"
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+const (
+	// DefaultMarkdownSize fits in a GitHub pull request comment
+	DefaultMarkdownSize = 65000
+
+	excerptLines    = 12
+	excerptLineSize = 300
+)
+
+// Digest is a compact Markdown summary of test results, suitable for pull
+// request comments and CI job summaries.
+type Digest struct {
+	Title     string
+	Libraries []test.TestResults // the last result for each board
+	FQBNs     []string
+
+	// Baseline is the path of the baseline the Changes were computed
+	// against, or empty if there's none
+	Baseline string
+	Changes  []compare.Change
+
+	// Logs, if set, is used to load the logs stored separately
+	Logs store.Store
+
+	// MaxSize caps the size of the digest, omitting the details that
+	// don't fit; zero means no limit
+	MaxSize int
+}
+
+// digestFailure is a failed compilation shown with an error excerpt.
+type digestFailure struct {
+	lib     string
+	version string
+	fqbn    string
+	example string // empty for the inclusion
+	status  test.CompatibilityStatus
+	isNew   bool
+	log     string
+	logHash string
+}
+
+// Markdown renders the digest: a table of the compatibility status of the
+// pairs of each board, the pairs newly failing compared to the baseline,
+// and collapsible error excerpts of the failed compilations, the new ones
+// and the ones claiming compatibility first.
+func (d Digest) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s\n\n", d.Title)
+
+	// Status table
+	statuses := []test.CompatibilityStatus{test.PASS_CLAIM, test.PASS_NOCLAIM, test.FAIL_CLAIM, test.FAIL_NOCLAIM}
+	counts := make(map[string]map[test.CompatibilityStatus]int)
+	for _, tr := range d.Libraries {
+		for _, t := range tr.Tests {
+			if counts[t.FQBN] == nil {
+				counts[t.FQBN] = make(map[test.CompatibilityStatus]int)
+			}
+			counts[t.FQBN][t.Status()]++
+		}
+	}
+	b.WriteString("| Board |")
+	for _, s := range statuses {
+		fmt.Fprintf(&b, " %s |", s)
+	}
+	b.WriteString("\n|---|")
+	for range statuses {
+		b.WriteString("---:|")
+	}
+	b.WriteString("\n")
+	for _, fqbn := range d.FQBNs {
+		fmt.Fprintf(&b, "| `%s` |", fqbn)
+		for _, s := range statuses {
+			fmt.Fprintf(&b, " %d |", counts[fqbn][s])
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	// The remaining sections are added while they fit
+	omitted := 0
+	fits := func(s string) bool {
+		// Leave room for the note about the omitted details
+		return d.MaxSize == 0 || b.Len()+len(s) <= d.MaxSize-200
+	}
+
+	// Newly failing pairs
+	type pairKey struct{ lib, fqbn, example string }
+	newFailures := make(map[pairKey]bool)
+	if d.Baseline != "" {
+		var lines []string
+		for _, c := range d.Changes {
+			switch c.Kind {
+			case compare.Regression, compare.NewFailClaim:
+				newFailures[pairKey{strings.ToLower(c.Library), c.FQBN, ""}] = true
+			case compare.ExampleRegression:
+				newFailures[pairKey{strings.ToLower(c.Library), c.FQBN, c.Example}] = true
+			default:
+				continue
+			}
+			lines = append(lines, "- "+markdownEscape(c.String())+"\n")
+		}
+		fmt.Fprintf(&b, "### Newly failing compared to %s\n\n", markdownEscape(d.Baseline))
+		if len(lines) == 0 {
+			b.WriteString("No new failures.\n")
+		}
+		for i, l := range lines {
+			if !fits(l) {
+				omitted += len(lines) - i
+				break
+			}
+			b.WriteString(l)
+		}
+		b.WriteString("\n")
+	}
+
+	// Error excerpts
+	var failures []digestFailure
+	for _, tr := range d.Libraries {
+		for _, t := range tr.Tests {
+			f := digestFailure{lib: tr.Name, version: t.Version, fqbn: t.FQBN, status: t.Status()}
+			if t.Result == test.FAIL {
+				f.log, f.logHash = t.Log, t.LogHash
+				f.isNew = newFailures[pairKey{strings.ToLower(tr.Name), t.FQBN, ""}]
+				failures = append(failures, f)
+			}
+			for _, e := range t.Examples {
+				if e.Result != test.FAIL {
+					continue
+				}
+				ef := f
+				ef.example, ef.log, ef.logHash = e.Name, e.Log, e.LogHash
+				ef.isNew = newFailures[pairKey{strings.ToLower(tr.Name), t.FQBN, e.Name}]
+				failures = append(failures, ef)
+			}
+		}
+	}
+	rank := func(f digestFailure) int {
+		switch {
+		case f.isNew:
+			return 0
+		case f.status == test.FAIL_CLAIM:
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(failures, func(i, j int) bool {
+		return rank(failures[i]) < rank(failures[j])
+	})
+	if len(failures) > 0 {
+		b.WriteString("### Errors\n\n")
+	}
+	for i, f := range failures {
+		// Once a failure doesn't fit the following ones are omitted too,
+		// without loading their logs
+		if details := d.details(f); fits(details) {
+			b.WriteString(details)
+		} else {
+			omitted += len(failures) - i
+			break
+		}
+	}
+
+	if omitted > 0 {
+		fmt.Fprintf(&b, "\n_%d more entries omitted to fit the size limit._\n", omitted)
+	}
+	return b.String()
+}
+
+// details renders a failure as a collapsible block with an excerpt of its
+// log.
+func (d Digest) details(f digestFailure) string {
+	log := f.log
+	if log == "" && f.logHash != "" && d.Logs != nil {
+		log, _ = d.Logs.Log(f.logHash)
+	}
+	what := "inclusion"
+	if f.example != "" {
+		what = "example " + f.example
+	}
+	var tags []string
+	if f.isNew {
+		tags = append(tags, "new")
+	}
+	tags = append(tags, string(f.status))
+	summary := fmt.Sprintf("<b>%s %s</b> on <code>%s</code>, %s (%s)",
+		htmlEscape(f.lib), htmlEscape(f.version), htmlEscape(f.fqbn), htmlEscape(what), strings.Join(tags, ", "))
+	return fmt.Sprintf("<details><summary>%s</summary>\n\n```\n%s\n```\n\n</details>\n", summary, excerpt(log))
+}
+
+// excerpt returns the error lines of a compilation log, or its last lines if
+// no errors can be recognized, trimmed to a few short lines.
+func excerpt(log string) string {
+	var lines []string
+	var all []string
+	for _, line := range strings.Split(log, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		all = append(all, line)
+		if d := test.ParseDiagnostics(line); len(d) > 0 && d[0].Severity == "error" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		lines = all
+		if len(lines) > excerptLines {
+			lines = lines[len(lines)-excerptLines:]
+		}
+	}
+	if len(lines) > excerptLines {
+		lines = append(lines[:excerptLines], fmt.Sprintf("... %d more errors", len(lines)-excerptLines))
+	}
+	for i, line := range lines {
+		if len(line) > excerptLineSize {
+			// Cut on a rune boundary, keeping the excerpt valid UTF-8
+			n := excerptLineSize
+			for n > 0 && !utf8.RuneStart(line[n]) {
+				n--
+			}
+			lines[i] = line[:n] + "..."
+		}
+		// Keep the code block closed
+		lines[i] = strings.ReplaceAll(lines[i], "```", "'''")
+	}
+	return strings.Join(lines, "\n")
+}
+
+var markdownReplacer = strings.NewReplacer("\\", "\\\\", "|", "\\|", "*", "\\*", "_", "\\_", "`", "\\`", "<", "&lt;", ">", "&gt;")
+
+func markdownEscape(s string) string {
+	return markdownReplacer.Replace(s)
+}
+
+var htmlReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+
+func htmlEscape(s string) string {
+	return htmlReplacer.Replace(s)
+}
+
+// writeMarkdown writes the digest of the last results to summary.md.
+func writeMarkdown(reportData aggregates, results store.Store, outputDir string, opts Options) ([]string, error) {
+	digest := Digest{
+		Title:    fmt.Sprintf("Compatibility of %d libraries", reportData.NumLibs),
+		Baseline: opts.BaselinePath,
+		Logs:     results,
+		MaxSize:  opts.MaxSize,
+	}
+	for _, board := range reportData.Boards {
+		digest.FQBNs = append(digest.FQBNs, board.Name)
+	}
+	for _, lib := range reportData.Libraries {
+		tr := test.TestResults{Name: lib.Name}
+		for _, fqbn := range digest.FQBNs {
+			if t, ok := lib.BoardTestResults[fqbn]; ok {
+				tr.Tests = append(tr.Tests, t)
+			}
+		}
+		digest.Libraries = append(digest.Libraries, tr)
+		if opts.Baseline != nil {
+			base, err := opts.Baseline.Get(lib.Name)
+			if err != nil {
+				return nil, err
+			}
+			digest.Changes = append(digest.Changes, compare.Compare(base, tr, nil)...)
+		}
+	}
+	file := path.Join(outputDir, "summary.md")
+	return []string{file}, util.WriteFileAtomic(file, []byte(digest.Markdown()), 0644)
+}
@@ -0,0 +1,70 @@
+package report
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/alranel/arduino-testlib/internal/compare"
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+func TestDigestMarkdown(t *testing.T) {
+	const fqbn = "arduino:avr:uno"
+	log := "Foo.cpp:1:10: fatal error: Bar.h: No such file or directory\n"
+	d := Digest{
+		Title: "Foo 1.0.0",
+		Libraries: []test.TestResults{{Name: "Foo", Tests: []test.TestResult{{
+			Version: "1.0.0", FQBN: fqbn, Core: "arduino:avr", Architectures: []string{"avr"}, Result: test.FAIL, Log: log,
+			Examples: []test.ExampleResult{{Name: "Blink", Result: test.PASS}},
+		}}}},
+		FQBNs:    []string{fqbn},
+		Baseline: "baseline.json",
+		Changes:  []compare.Change{{Kind: compare.Regression, Library: "Foo", FQBN: fqbn}},
+	}
+	md := d.Markdown()
+	for _, s := range []string{
+		"## Foo 1.0.0\n",
+		"| `arduino:avr:uno` | 0 | 0 | 1 | 0 |\n",
+		"### Newly failing compared to baseline.json\n",
+		"<details><summary><b>Foo 1.0.0</b> on <code>arduino:avr:uno</code>, inclusion (new, FAIL_CLAIM)</summary>\n\n```\n" +
+			strings.TrimSpace(log) + "\n```\n\n</details>\n",
+	} {
+		if !strings.Contains(md, s) {
+			t.Errorf("digest lacks %q:\n%s", s, md)
+		}
+	}
+	if strings.Contains(md, "Blink") {
+		t.Errorf("digest shows the passing example:\n%s", md)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	// Without recognizable errors the last lines are kept
+	var lines []string
+	for i := 0; i < excerptLines+3; i++ {
+		lines = append(lines, strings.Repeat("x", i+1))
+	}
+	got := excerpt(strings.Join(lines, "\r\n") + "\n\n")
+	if want := strings.Join(lines[3:], "\n"); got != want {
+		t.Errorf("excerpt = %q, want %q", got, want)
+	}
+
+	// Long lines are cut on a rune boundary, whatever the offset of the
+	// multi-byte characters
+	for offset := 0; offset < 3; offset++ {
+		line := strings.Repeat("a", offset) + strings.Repeat("é€", excerptLineSize)
+		got := excerpt(line)
+		if !utf8.ValidString(got) {
+			t.Errorf("excerpt of a line with offset %d isn't valid UTF-8: %q", offset, got)
+		}
+		if !strings.HasSuffix(got, "...") || len(got) > excerptLineSize+len("...") || len(got) < excerptLineSize-utf8.UTFMax {
+			t.Errorf("excerpt of a line with offset %d has length %d", offset, len(got))
+		}
+	}
+
+	// Code fences in the log don't close the block
+	if got := excerpt("```"); got != "'''" {
+		t.Errorf("excerpt = %q, want %q", got, "'''")
+	}
+}
@@ -3,7 +3,6 @@ package report
 import (
 	"fmt"
 	"html/template"
-	"io/ioutil"
 	"os"
 	"path"
 	"regexp"
@@ -11,37 +10,146 @@ import (
 	"strings"
 	"time"
 
+	"github.com/alranel/arduino-testlib/internal/compare"
+	"github.com/alranel/arduino-testlib/internal/store"
 	"github.com/alranel/arduino-testlib/internal/util"
 	"github.com/alranel/arduino-testlib/pkg/test"
 	"github.com/arduino/arduino-cli/arduino/utils"
 	"golang.org/x/mod/semver"
 )
 
-func Generate(datadirPath string, outputDir string) {
+type coreReportData struct {
+	Architecture string `json:"architecture"`
+	Claim        int    `json:"claim"`
+}
+
+type boardReportData struct {
+	Name              string `json:"name"`
+	Architecture      string `json:"architecture"`
+	Versions          string `json:"versions"`
+	Claim             int    `json:"claim"`
+	ExplicitClaim     int    `json:"explicit_claim"`
+	ClaimMismatch     int    `json:"claim_mismatch"`
+	Pass              int    `json:"pass"`
+	Fail              int    `json:"fail"`
+	Untested          int    `json:"untested"`
+	PassClaim         int    `json:"pass_claim"`
+	PassNoClaim       int    `json:"pass_noclaim"`
+	FailClaim         int    `json:"fail_claim"`
+	FailClaimAsterisk int    `json:"fail_claim_asterisk"`
+	FailExplicitClaim int    `json:"fail_explicit_claim"`
+}
+
+type libraryReportData struct {
+	Name, ReportFile, Version, URL string
+	BoardCompatibility             map[string]test.CompatibilityStatus
+	BoardTestResults               map[string]test.TestResult
+	Examples                       []string
+	Metadata                       test.Metadata // of the last tested version
+}
+
+type exampleReportData struct {
+	Num   int `json:"num"`
+	Count int `json:"count"`
+}
+
+// aggregates holds the statistics rendered by the report.
+type aggregates struct {
+	Timestamp                                   string
+	NumLibs, NumBoards                          int
+	NumLibsCompatibilityAsterisk                int
+	NumLibsClaimNoBoards, NumLibsClaimAllBoards int
+	NumLibsPassNoBoards, NumLibsPassAllBoards   int
+	NumLibsFailClaim                            int
+	NumLibsAsteriskFail                         int
+	HasUntested                                 bool
+	Cores                                       []coreReportData
+	Boards                                      []boardReportData
+	Examples                                    []exampleReportData
+	Libraries                                   []libraryReportData
+	Trends                                      trendsData
+	Maintainers                                 []maintainerReportData
+	Categories                                  []categoryReportData
+	NumLibsNoMetadata                           int
+}
+
+// Formats lists the formats the report can be written in.
+var Formats = []string{"html", "json", "csv", "markdown"}
+
+// Options control the report.
+type Options struct {
+	Format string // one of Formats
+
+	// Baseline, if set, is compared with the results by the markdown
+	// format, listing the pairs newly failing
+	Baseline     compare.Baseline
+	BaselinePath string
+
+	// MaxSize caps the size of the markdown format
+	MaxSize int
+}
+
+// Generate prints a summary of the test results and writes a report to
+// outputDir.
+func Generate(results store.Store, outputDir string, opts Options) {
+	reportData, err := collect(results)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read test results: %v\n", err)
+		os.Exit(1)
+	}
+	printSummary(reportData)
+
+	os.Mkdir(outputDir, os.ModePerm)
+	var files []string
+	switch opts.Format {
+	case "html":
+		files, err = writeHTML(reportData, results, outputDir)
+	case "json":
+		files, err = writeJSON(reportData, outputDir)
+	case "csv":
+		files, err = writeCSV(reportData, outputDir)
+	case "markdown":
+		files, err = writeMarkdown(reportData, results, outputDir, opts)
+	default:
+		err = fmt.Errorf("unknown format: %s", opts.Format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating report: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\nReport written to %s\n", strings.Join(files, ", "))
+}
+
+// collect computes the aggregates of the last test results of each
+// library/board pair.
+func collect(results store.Store) (aggregates, error) {
 	// Prepare the data structures
 	libraries := make(map[string]string)
 	boards := make(map[string]map[string]bool)
 	type libBoardPair struct{ lib, board string }
-	type compatibilityStatus string
-	const (
-		PASS_CLAIM   compatibilityStatus = "PASS_CLAIM"
-		PASS_NOCLAIM compatibilityStatus = "PASS_NOCLAIM"
-		FAIL_CLAIM   compatibilityStatus = "FAIL_CLAIM"
-		FAIL_NOCLAIM compatibilityStatus = "FAIL_NOCLAIM"
-	)
-	compatibility := make(map[libBoardPair]compatibilityStatus)
+	compatibility := make(map[libBoardPair]test.CompatibilityStatus)
 	compatibilityAsterisk := make(map[string]bool) // lib => has_asterisk
 	claimedCompatibility := make(map[string]int)   // core => number of libs
 	testResults := make(map[libBoardPair]test.TestResult)
 	numExamples := make(map[int]int)
+	metadata := make(map[string]test.Metadata)
+	var history []runTest // for the trends
+	untimed := 0
 
 	// Read library data
-	files, _ := ioutil.ReadDir(datadirPath)
-	for _, file := range files {
-		// Read test results file
-		var tr test.TestResults
-		if !test.ReadResultsFile(path.Join(datadirPath, file.Name()), &tr) {
-			continue
+	err := results.Walk(func(tr test.TestResults) error {
+		for _, t := range tr.Tests {
+			// Tests are recorded in order, so the last metadata is the most
+			// recent one
+			if t.Layout != "" {
+				metadata[tr.Name] = t.Metadata
+			}
+			if t.Run == "" {
+				untimed++
+			}
+		}
+		for _, h := range tr.RunHistory() {
+			history = append(history, runTest{tr.Name, h.FQBN, h.Run, h.Status})
 		}
 
 		// Sort tests by lib version and core version
@@ -70,22 +178,7 @@ func Generate(datadirPath string, outputDir string) {
 			boards[t.FQBN][t.CoreVersion] = true
 			nEx = len(t.Examples)
 
-			// Find the compatibility status
-			var cSt compatibilityStatus
-			if t.Result == test.PASS {
-				if util.CoreInArchitectures(t.Core, t.Architectures) {
-					cSt = PASS_CLAIM
-				} else {
-					cSt = PASS_NOCLAIM
-				}
-			} else {
-				if util.CoreInArchitectures(t.Core, t.Architectures) {
-					cSt = FAIL_CLAIM
-				} else {
-					cSt = FAIL_NOCLAIM
-				}
-			}
-			compatibility[libBoardPair{tr.Name, t.FQBN}] = cSt
+			compatibility[libBoardPair{tr.Name, t.FQBN}] = t.Status()
 			testResults[libBoardPair{tr.Name, t.FQBN}] = t
 		}
 		if len(tr.Tests) > 0 {
@@ -100,44 +193,15 @@ func Generate(datadirPath string, outputDir string) {
 			}
 		}
 		numExamples[nEx] = numExamples[nEx] + 1
+		return nil
+	})
+	if err != nil {
+		return aggregates{}, err
 	}
 
 	// Compute statistics
 	numLibs := len(libraries)
-	percent := func(n int) string { return fmt.Sprintf("%.1f%%", float32(n)/float32(numLibs)*100) }
-
-	type coreReportData struct {
-		Architecture string
-		Claim        int
-	}
-	type boardReportData struct {
-		Name, Architecture, Versions                                            string
-		Claim, ExplicitClaim, ClaimMismatch, Pass, Fail, Untested               int
-		PassClaim, PassNoClaim, FailClaim, FailClaimAsterisk, FailExplicitClaim int
-	}
-	type libraryReportData struct {
-		Name, ReportFile, Version, URL string
-		BoardCompatibility             map[string]compatibilityStatus
-		BoardTestResults               map[string]test.TestResult
-		Examples                       []string
-	}
-	type exampleReportData struct {
-		Num, Count int
-	}
-	reportData := struct {
-		Timestamp                                   string
-		NumLibs, NumBoards                          int
-		NumLibsCompatibilityAsterisk                int
-		NumLibsClaimNoBoards, NumLibsClaimAllBoards int
-		NumLibsPassNoBoards, NumLibsPassAllBoards   int
-		NumLibsFailClaim                            int
-		NumLibsAsteriskFail                         int
-		HasUntested                                 bool
-		Cores                                       []coreReportData
-		Boards                                      []boardReportData
-		Examples                                    []exampleReportData
-		Libraries                                   []libraryReportData
-	}{
+	reportData := aggregates{
 		Timestamp:                    time.Now().Format(time.RFC850),
 		NumLibs:                      numLibs,
 		NumBoards:                    len(boards),
@@ -179,25 +243,25 @@ func Generate(datadirPath string, outputDir string) {
 			Versions:     strings.Join(versions, ","),
 		}
 
-		cnt := make(map[compatibilityStatus]int)
+		cnt := make(map[test.CompatibilityStatus]int)
 		failClaimAsterisk := 0
 		for pair, result := range compatibility {
 			if pair.board == board {
 				cnt[result] = cnt[result] + 1
-				if result == FAIL_CLAIM && compatibilityAsterisk[pair.lib] {
+				if result == test.FAIL_CLAIM && compatibilityAsterisk[pair.lib] {
 					failClaimAsterisk = failClaimAsterisk + 1
 				}
 			}
 		}
-		c.Claim = cnt[PASS_CLAIM] + cnt[FAIL_CLAIM]
+		c.Claim = cnt[test.PASS_CLAIM] + cnt[test.FAIL_CLAIM]
 		c.ExplicitClaim = c.Claim - reportData.NumLibsCompatibilityAsterisk
-		c.ClaimMismatch = cnt[PASS_NOCLAIM] + cnt[FAIL_CLAIM]
-		c.Pass = cnt[PASS_CLAIM] + cnt[PASS_NOCLAIM]
-		c.Fail = cnt[FAIL_CLAIM] + cnt[FAIL_NOCLAIM]
+		c.ClaimMismatch = cnt[test.PASS_NOCLAIM] + cnt[test.FAIL_CLAIM]
+		c.Pass = cnt[test.PASS_CLAIM] + cnt[test.PASS_NOCLAIM]
+		c.Fail = cnt[test.FAIL_CLAIM] + cnt[test.FAIL_NOCLAIM]
 		c.Untested = numLibs - (c.Pass + c.Fail)
-		c.PassClaim = cnt[PASS_CLAIM]
-		c.PassNoClaim = cnt[PASS_NOCLAIM]
-		c.FailClaim = cnt[FAIL_CLAIM]
+		c.PassClaim = cnt[test.PASS_CLAIM]
+		c.PassNoClaim = cnt[test.PASS_NOCLAIM]
+		c.FailClaim = cnt[test.FAIL_CLAIM]
 		c.FailClaimAsterisk = failClaimAsterisk
 		c.FailExplicitClaim = c.FailClaim - c.FailClaimAsterisk
 		if c.Untested > 0 {
@@ -210,6 +274,13 @@ func Generate(datadirPath string, outputDir string) {
 		return reportData.Boards[i].Name < reportData.Boards[j].Name
 	})
 
+	// Trends
+	var boardNames []string
+	for _, b := range reportData.Boards {
+		boardNames = append(boardNames, b.Name)
+	}
+	reportData.Trends = computeTrends(history, boardNames, untimed)
+
 	// Library statistics
 	var libNames []string
 	for lib := range libraries {
@@ -222,8 +293,9 @@ func Generate(datadirPath string, outputDir string) {
 			ReportFile:         utils.SanitizeName(lib) + ".html",
 			URL:                libraryURL(lib),
 			Version:            libraries[lib],
-			BoardCompatibility: make(map[string]compatibilityStatus),
+			BoardCompatibility: make(map[string]test.CompatibilityStatus),
 			BoardTestResults:   make(map[string]test.TestResult),
+			Metadata:           metadata[lib],
 		}
 		totClaim := 0
 		totFailClaim := 0
@@ -232,16 +304,16 @@ func Generate(datadirPath string, outputDir string) {
 		for pair, result := range compatibility {
 			if pair.lib == lib {
 				lData.BoardCompatibility[pair.board] = result
-				if result == PASS_CLAIM || result == FAIL_CLAIM {
+				if result == test.PASS_CLAIM || result == test.FAIL_CLAIM {
 					totClaim = totClaim + 1
 				}
-				if result == FAIL_CLAIM {
+				if result == test.FAIL_CLAIM {
 					totFailClaim = totFailClaim + 1
 				}
-				if result == PASS_CLAIM || result == PASS_NOCLAIM {
+				if result == test.PASS_CLAIM || result == test.PASS_NOCLAIM {
 					totPass = totPass + 1
 				}
-				if result == FAIL_CLAIM || result == FAIL_NOCLAIM {
+				if result == test.FAIL_CLAIM || result == test.FAIL_NOCLAIM {
 					totFail = totFail + 1
 				}
 			}
@@ -287,7 +359,20 @@ func Generate(datadirPath string, outputDir string) {
 		return reportData.Examples[i].Num < reportData.Examples[j].Num
 	})
 
-	// Output results to console
+	// Maintainer and category statistics
+	reportData.Maintainers, reportData.Categories, reportData.NumLibsNoMetadata = groupLibraries(reportData.Libraries, reportData.Boards)
+	return reportData, nil
+}
+
+// percentFunc returns a function formatting a number of libraries as a
+// percentage of the total.
+func percentFunc(numLibs int) func(n int) string {
+	return func(n int) string { return fmt.Sprintf("%.1f%%", float32(n)/float32(numLibs)*100) }
+}
+
+// printSummary outputs the board statistics to the console.
+func printSummary(reportData aggregates) {
+	percent := percentFunc(reportData.NumLibs)
 	fmt.Printf("Tested libraries: %d\n\n", reportData.NumLibs)
 	for _, c := range reportData.Boards {
 		fmt.Printf("%s @ %s\n", c.Name, c.Versions)
@@ -309,20 +394,58 @@ func Generate(datadirPath string, outputDir string) {
 	for _, e := range reportData.Examples {
 		fmt.Printf("- %d: %d (%s)\n", e.Num, e.Count, percent(e.Count))
 	}
+}
 
-	// Write an HTML report
+// writeHTML writes the main HTML report and a page per library.
+func writeHTML(reportData aggregates, results store.Store, outputDir string) ([]string, error) {
+	percent := percentFunc(reportData.NumLibs)
+	index := path.Join(outputDir, "index.html")
 	{
 		templ, err := template.New("report").Funcs(template.FuncMap{"percent": percent}).Parse(htmlTmpl)
 		if err != nil {
 			panic(err)
 		}
-		os.Mkdir(outputDir, os.ModePerm)
-		f, err := os.Create(path.Join(outputDir, "index.html"))
+		f, err := os.Create(index)
+		if err != nil {
+			return nil, err
+		}
 		defer f.Close()
 		err = templ.Execute(f, reportData)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error generating HTML report: %v\n", err)
-			os.Exit(1)
+			return nil, err
+		}
+	}
+
+	// Write the trends page
+	if reportData.Trends.NumRuns > 0 {
+		if err := writePage(outputDir, "trends.html", htmlTmplTrends, reportData); err != nil {
+			return nil, err
+		}
+	}
+
+	// Write the maintainer and category pages
+	if len(reportData.Maintainers) > 0 {
+		if err := writePage(outputDir, "maintainers.html", htmlTmplMaintainers, reportData); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range reportData.Maintainers {
+		data := struct {
+			Timestamp  string
+			Maintainer maintainerReportData
+		}{reportData.Timestamp, m}
+		if err := writePage(outputDir, m.ReportFile, htmlTmplMaintainer, data); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range reportData.Categories {
+		data := struct {
+			Timestamp string
+			Category  categoryReportData
+			Boards    []boardReportData
+		}{reportData.Timestamp, c, reportData.Boards}
+		if err := writePage(outputDir, c.ReportFile, htmlTmplCategory, data); err != nil {
+			return nil, err
 		}
 	}
 
@@ -332,10 +455,20 @@ func Generate(datadirPath string, outputDir string) {
 		panic(err)
 	}
 	for _, lib := range reportData.Libraries {
+		// Logs are only loaded when writing the library page
+		boardTestResults := make(map[string]test.TestResult)
+		for board, t := range lib.BoardTestResults {
+			tr := test.TestResults{Tests: []test.TestResult{t}}
+			if err := store.LoadLogs(results, &tr); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not load logs of %s: %v\n", lib.Name, err)
+			}
+			boardTestResults[board] = tr.Tests[0]
+		}
+		lib.BoardTestResults = boardTestResults
+
 		f, err := os.Create(path.Join(outputDir, lib.ReportFile))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating file: %s: %v\n", lib.ReportFile, err)
-			os.Exit(1)
+			return nil, err
 		}
 		data := struct {
 			Timestamp string
@@ -347,13 +480,30 @@ func Generate(datadirPath string, outputDir string) {
 			Boards:    reportData.Boards,
 		}
 		err = templ.Execute(f, data)
+		f.Close()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error generating HTML report: %s: %v\n", lib.ReportFile, err)
-			os.Exit(1)
+			return nil, fmt.Errorf("%s: %v", lib.ReportFile, err)
 		}
-		f.Close()
 	}
-	fmt.Printf("\nHTML report written to %s\n", path.Join(outputDir, "index.html"))
+	return []string{index}, nil
+}
+
+// writePage writes an HTML page of the report from a template.
+func writePage(outputDir string, file string, tmpl string, data interface{}) error {
+	templ, err := template.New("report").Parse(tmpl)
+	if err != nil {
+		panic(err)
+	}
+	f, err := os.Create(path.Join(outputDir, file))
+	if err != nil {
+		return err
+	}
+	err = templ.Execute(f, data)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	return nil
 }
 
 // This is the same function used to generate the library directory on the Arduino.cc website
@@ -0,0 +1,296 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// maxFlipsPerRun caps the flips listed for each run.
+const maxFlipsPerRun = 100
+
+// runTest is a test of a library/board pair recorded with its run.
+type runTest struct {
+	lib    string
+	board  string
+	run    string
+	status test.CompatibilityStatus
+}
+
+type boardTrend struct {
+	Tested    int
+	Pass      int
+	FailClaim int
+}
+
+func (b boardTrend) PassRate() float64 {
+	if b.Tested == 0 {
+		return math.NaN()
+	}
+	return float64(b.Pass) / float64(b.Tested)
+}
+
+func (b boardTrend) FailClaimRate() float64 {
+	if b.Tested == 0 {
+		return math.NaN()
+	}
+	return float64(b.FailClaim) / float64(b.Tested)
+}
+
+// flip is a library/board pair whose status changed in a run.
+type flip struct {
+	Library, Board string
+	Before, After  test.CompatibilityStatus
+}
+
+// runTrend describes the results as of a run: the boards count the last
+// result of every pair tested in that run or before it, so that incremental
+// runs are comparable with complete ones.
+type runTrend struct {
+	Run            string
+	Libraries      int // tested in the run
+	Boards         map[string]boardTrend
+	Flips          []flip
+	MoreFlips      int // not listed
+	NumFlips       int
+	PassRates      []string // formatted, in the order of the boards
+	FailClaimRates []string
+}
+
+type trendsData struct {
+	Runs                []runTrend // the newest first
+	PassRateChart       template.HTML
+	FailClaimRateChart  template.HTML
+	LibrariesChart      template.HTML
+	NumRuns, NumUntimed int
+}
+
+// computeTrends replays the tests in the order of their runs. Tests
+// recorded without a run are not considered.
+func computeTrends(tests []runTest, boards []string, untimed int) trendsData {
+	sort.SliceStable(tests, func(i, j int) bool {
+		return test.RunBefore(tests[i].run, tests[j].run)
+	})
+
+	type pair struct{ lib, board string }
+	current := make(map[pair]test.CompatibilityStatus)
+	counts := make(map[string]boardTrend)
+	count := func(board string, status test.CompatibilityStatus, delta int) {
+		b := counts[board]
+		b.Tested += delta
+		if status == test.PASS_CLAIM || status == test.PASS_NOCLAIM {
+			b.Pass += delta
+		}
+		if status == test.FAIL_CLAIM {
+			b.FailClaim += delta
+		}
+		counts[board] = b
+	}
+
+	var runs []runTrend
+	for i := 0; i < len(tests); {
+		r := runTrend{Run: tests[i].run, Boards: make(map[string]boardTrend)}
+		libs := make(map[string]bool)
+		// The same run may be recorded with different time zones
+		for ; i < len(tests) && !test.RunBefore(r.Run, tests[i].run); i++ {
+			t := tests[i]
+			libs[t.lib] = true
+			p := pair{t.lib, t.board}
+			before, seen := current[p]
+			if seen {
+				if before == t.status {
+					continue
+				}
+				count(t.board, before, -1)
+				r.NumFlips++
+				if len(r.Flips) < maxFlipsPerRun {
+					r.Flips = append(r.Flips, flip{t.lib, t.board, before, t.status})
+				}
+			}
+			current[p] = t.status
+			count(t.board, t.status, 1)
+		}
+		r.Libraries = len(libs)
+		r.MoreFlips = r.NumFlips - len(r.Flips)
+		sort.Slice(r.Flips, func(a, b int) bool {
+			if r.Flips[a].Library != r.Flips[b].Library {
+				return r.Flips[a].Library < r.Flips[b].Library
+			}
+			return r.Flips[a].Board < r.Flips[b].Board
+		})
+		for _, board := range boards {
+			b := counts[board]
+			r.Boards[board] = b
+			r.PassRates = append(r.PassRates, formatRate(b.PassRate()))
+			r.FailClaimRates = append(r.FailClaimRates, formatRate(b.FailClaimRate()))
+		}
+		runs = append(runs, r)
+	}
+
+	// Charts, the oldest run first
+	var labels []string
+	var libraries []float64
+	passRates := make([]chartSeries, len(boards))
+	failClaimRates := make([]chartSeries, len(boards))
+	for i, board := range boards {
+		passRates[i].Name, failClaimRates[i].Name = board, board
+	}
+	for _, r := range runs {
+		labels = append(labels, r.Run)
+		libraries = append(libraries, float64(r.Libraries))
+		for i, board := range boards {
+			passRates[i].Values = append(passRates[i].Values, r.Boards[board].PassRate()*100)
+			failClaimRates[i].Values = append(failClaimRates[i].Values, r.Boards[board].FailClaimRate()*100)
+		}
+	}
+	data := trendsData{NumRuns: len(runs), NumUntimed: untimed}
+	if len(runs) > 0 {
+		percent := func(v float64) string { return fmt.Sprintf("%.0f%%", v) }
+		data.PassRateChart = lineChart(labels, passRates, 100, percent)
+		data.FailClaimRateChart = lineChart(labels, failClaimRates, 100, percent)
+		data.LibrariesChart = barChart(labels, libraries)
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		data.Runs = append(data.Runs, runs[i])
+	}
+	return data
+}
+
+func formatRate(r float64) string {
+	if math.IsNaN(r) {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", r*100)
+}
+
+// chartSeries is a line of a chart; NaN values are gaps.
+type chartSeries struct {
+	Name   string
+	Values []float64
+}
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+const (
+	chartWidth  = 800
+	chartHeight = 240
+	chartLeft   = 50
+	chartRight  = 10
+	chartTop    = 10
+	chartBottom = 30
+)
+
+// chartX returns the horizontal position of the i-th of n points.
+func chartX(i int, n int) float64 {
+	if n <= 1 {
+		return chartLeft + float64(chartWidth-chartLeft-chartRight)/2
+	}
+	return chartLeft + float64(i)*float64(chartWidth-chartLeft-chartRight)/float64(n-1)
+}
+
+func chartY(v float64, yMax float64) float64 {
+	return chartTop + (1-v/yMax)*float64(chartHeight-chartTop-chartBottom)
+}
+
+// chartAxes draws the horizontal grid with its labels and the labels of
+// some of the runs.
+func chartAxes(b *strings.Builder, labels []string, yMax float64, yFormat func(float64) string) {
+	for i := 0; i <= 4; i++ {
+		v := yMax * float64(i) / 4
+		y := chartY(v, yMax)
+		fmt.Fprintf(b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`, chartLeft, y, chartWidth-chartRight, y)
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" font-size="11" text-anchor="end" dominant-baseline="middle">%s</text>`, chartLeft-5, y, template.HTMLEscapeString(yFormat(v)))
+	}
+	step := (len(labels) + 5) / 6
+	if step < 1 {
+		step = 1
+	}
+	for i := 0; i < len(labels); i += step {
+		date := labels[i]
+		if len(date) > 10 {
+			date = date[:10]
+		}
+		fmt.Fprintf(b, `<text x="%.1f" y="%d" font-size="11" text-anchor="middle">%s</text>`, chartX(i, len(labels)), chartHeight-10, template.HTMLEscapeString(date))
+	}
+}
+
+// lineChart draws the series as lines on a chart ranging from 0 to yMax,
+// with a legend below.
+func lineChart(labels []string, series []chartSeries, yMax float64, yFormat func(float64) string) template.HTML {
+	// Lay out the legend in rows
+	type legendItem struct {
+		x, row int
+	}
+	legend := make([]legendItem, len(series))
+	x, row := chartLeft, 0
+	for i, s := range series {
+		width := 25 + 7*len(s.Name)
+		if x+width > chartWidth && x > chartLeft {
+			x, row = chartLeft, row+1
+		}
+		legend[i] = legendItem{x, row}
+		x += width + 15
+	}
+	height := chartHeight + 20*(row+1)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%" role="img">`, chartWidth, height)
+	chartAxes(&b, labels, yMax, yFormat)
+	for i, s := range series {
+		color := chartColors[i%len(chartColors)]
+		// Gaps split the line into segments
+		var points []string
+		flush := func() {
+			if len(points) > 1 {
+				fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`, color, strings.Join(points, " "))
+			}
+			points = nil
+		}
+		for j, v := range s.Values {
+			if math.IsNaN(v) {
+				flush()
+				continue
+			}
+			px, py := chartX(j, len(s.Values)), chartY(v, yMax)
+			points = append(points, fmt.Sprintf("%.1f,%.1f", px, py))
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s, %s: %s</title></circle>`,
+				px, py, color, template.HTMLEscapeString(s.Name), template.HTMLEscapeString(labels[j]), template.HTMLEscapeString(yFormat(v)))
+		}
+		flush()
+
+		l := legend[i]
+		y := chartHeight + 20*l.row + 10
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="15" height="4" fill="%s"/>`, l.x, y-2, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="12" dominant-baseline="middle">%s</text>`, l.x+20, y, template.HTMLEscapeString(s.Name))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// barChart draws the values as bars.
+func barChart(labels []string, values []float64) template.HTML {
+	yMax := 1.0
+	for _, v := range values {
+		yMax = math.Max(yMax, v)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%" role="img">`, chartWidth, chartHeight)
+	chartAxes(&b, labels, yMax, func(v float64) string { return fmt.Sprintf("%.0f", v) })
+	width := float64(chartWidth-chartLeft-chartRight) / float64(len(values)+1) * 0.8
+	for i, v := range values {
+		x := chartX(i, len(values))
+		if len(values) > 1 {
+			// Keep the first and last bars inside the chart
+			x = chartLeft + width/2 + float64(i)*(float64(chartWidth-chartLeft-chartRight)-width)/float64(len(values)-1)
+		}
+		y := chartY(v, yMax)
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %.0f</title></rect>`,
+			x-width/2, y, width, chartY(0, yMax)-y, chartColors[0], template.HTMLEscapeString(labels[i]), v)
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
@@ -0,0 +1,39 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+func TestComputeTrendsTimeZones(t *testing.T) {
+	const board = "arduino:avr:uno"
+	// As strings, the run at 09:00 UTC sorts after the one at 10:00 UTC, and
+	// the first, third and fourth tests belong to the same run
+	tests := []runTest{
+		{lib: "Foo", board: board, run: "2022-05-02T10:00:00Z", status: test.FAIL_CLAIM},
+		{lib: "Foo", board: board, run: "2022-05-02T11:00:00+02:00", status: test.PASS_CLAIM},
+		{lib: "Bar", board: board, run: "2022-05-02T12:00:00+02:00", status: test.PASS_CLAIM},
+		{lib: "Baz", board: board, run: "2022-05-02T10:00:00Z", status: test.PASS_CLAIM},
+	}
+	data := computeTrends(tests, []string{board}, 0)
+
+	var runs []string
+	for _, r := range data.Runs {
+		runs = append(runs, r.Run)
+	}
+	if want := []string{"2022-05-02T10:00:00Z", "2022-05-02T11:00:00+02:00"}; !reflect.DeepEqual(runs, want) {
+		t.Fatalf("runs = %v, want %v", runs, want)
+	}
+	last := data.Runs[0]
+	if last.Libraries != 3 || last.NumFlips != 1 {
+		t.Errorf("last run has %d libraries and %d flips, want 3 and 1", last.Libraries, last.NumFlips)
+	}
+	if want := (flip{"Foo", board, test.PASS_CLAIM, test.FAIL_CLAIM}); len(last.Flips) != 1 || last.Flips[0] != want {
+		t.Errorf("flips = %+v, want %+v", last.Flips, want)
+	}
+	if b := last.Boards[board]; b.Tested != 3 || b.FailClaim != 1 {
+		t.Errorf("board trend = %+v, want 3 tested and 1 failing", b)
+	}
+}
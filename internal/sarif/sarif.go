@@ -0,0 +1,221 @@
+package sarif
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// The subset of the SARIF 2.1.0 format used to report diagnostics.
+
+type message struct {
+	Text string `json:"text"`
+}
+
+type artifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type region struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type physicalLocation struct {
+	ArtifactLocation artifactLocation `json:"artifactLocation"`
+	Region           region           `json:"region"`
+}
+
+type location struct {
+	PhysicalLocation physicalLocation `json:"physicalLocation"`
+}
+
+type result struct {
+	RuleID    string     `json:"ruleId"`
+	RuleIndex int        `json:"ruleIndex"`
+	Level     string     `json:"level"`
+	Message   message    `json:"message"`
+	Locations []location `json:"locations"`
+}
+
+type configuration struct {
+	Level string `json:"level"`
+}
+
+type rule struct {
+	ID                   string        `json:"id"`
+	ShortDescription     message       `json:"shortDescription"`
+	DefaultConfiguration configuration `json:"defaultConfiguration"`
+}
+
+type driver struct {
+	Name           string `json:"name"`
+	InformationURI string `json:"informationUri"`
+	Rules          []rule `json:"rules"`
+}
+
+type tool struct {
+	Driver driver `json:"driver"`
+}
+
+type automationDetails struct {
+	ID string `json:"id"`
+}
+
+type run struct {
+	Tool               tool                        `json:"tool"`
+	AutomationDetails  automationDetails           `json:"automationDetails"`
+	OriginalURIBaseIDs map[string]artifactLocation `json:"originalUriBaseIds"`
+	Results            []result                    `json:"results"`
+	Properties         map[string]string           `json:"properties"`
+}
+
+type log struct {
+	Schema  string `json:"$schema"`
+	Version string `json:"version"`
+	Runs    []run  `json:"runs"`
+}
+
+// errorCategories classifies the GCC errors, which have no flag.
+var errorCategories = []struct {
+	re   *regexp.Regexp
+	rule string
+}{
+	{regexp.MustCompile(`No such file or directory`), "missing-include"},
+	{regexp.MustCompile(`^#error`), "error-directive"},
+	{regexp.MustCompile(`was not declared in this scope|undeclared`), "undeclared-identifier"},
+	{regexp.MustCompile(`does not name a type|unknown type name`), "unknown-type"},
+	{regexp.MustCompile(`has no member named|is not a member of`), "no-member"},
+	{regexp.MustCompile(`no matching function for call|no matching constructor`), "no-matching-function"},
+	{regexp.MustCompile(`redefinition of|conflicting declaration|conflicting types`), "redefinition"},
+	{regexp.MustCompile(`invalid conversion|cannot convert`), "invalid-conversion"},
+	{regexp.MustCompile(`^expected `), "syntax-error"},
+}
+
+// Rule returns the rule of a diagnostic: the warning flag without -W, or the
+// category of an error.
+func Rule(d test.Diagnostic) string {
+	if d.Flag != "" {
+		return strings.TrimPrefix(d.Flag, "-W")
+	}
+	if d.Severity == "warning" {
+		return "warning"
+	}
+	for _, c := range errorCategories {
+		if c.re.MatchString(d.Message) {
+			return c.rule
+		}
+	}
+	return "error"
+}
+
+// Generate returns a SARIF log of the errors and warnings found in the
+// compilation logs of the given tests, with a run for each FQBN. Only the
+// diagnostics located in the library are reported, with paths relative to
+// libPath.
+func Generate(libPath string, tests []test.TestResult) ([]byte, error) {
+	roots := []string{libPath}
+	if resolved, err := filepath.EvalSymlinks(libPath); err == nil && resolved != libPath {
+		roots = append(roots, resolved)
+	}
+	relative := func(file string) (string, bool) {
+		for _, root := range roots {
+			if rel, err := filepath.Rel(root, file); err == nil && !strings.HasPrefix(rel, "..") && !filepath.IsAbs(rel) {
+				return filepath.ToSlash(rel), true
+			}
+		}
+		return "", false
+	}
+
+	l := log{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []run{},
+	}
+	for _, t := range tests {
+		r := run{
+			Tool: tool{Driver: driver{
+				Name:           "arduino-testlib",
+				InformationURI: "https://github.com/alranel/arduino-testlib",
+				Rules:          []rule{},
+			}},
+			// Distinguishes the runs of each board for code scanning
+			AutomationDetails: automationDetails{ID: t.FQBN + "/"},
+			OriginalURIBaseIDs: map[string]artifactLocation{
+				"SRCROOT": {URI: "file://" + filepath.ToSlash(libPath) + "/"},
+			},
+			Results: []result{},
+			Properties: map[string]string{
+				"fqbn":         t.FQBN,
+				"core":         t.Core,
+				"core_version": t.CoreVersion,
+				"version":      t.Version,
+			},
+		}
+		ruleIndex := make(map[string]int)
+		seen := make(map[string]bool)
+		logs := []string{t.Log}
+		for _, e := range t.Examples {
+			logs = append(logs, e.Log)
+		}
+		for _, compilationLog := range logs {
+			for _, d := range test.ParseDiagnostics(compilationLog) {
+				if d.Severity == "note" {
+					continue
+				}
+				uri, ok := relative(d.File)
+				if !ok {
+					continue
+				}
+				id := Rule(d)
+				key := fmt.Sprintf("%s:%d:%d:%s:%s", uri, d.Line, d.Column, id, d.Message)
+				if seen[key] {
+					// The same file is compiled by the inclusion and by
+					// each example
+					continue
+				}
+				seen[key] = true
+				if _, ok := ruleIndex[id]; !ok {
+					level := d.Severity
+					description := "GCC warning " + d.Flag
+					if d.Flag == "" {
+						description = "GCC " + strings.ReplaceAll(id, "-", " ")
+					}
+					ruleIndex[id] = len(r.Tool.Driver.Rules)
+					r.Tool.Driver.Rules = append(r.Tool.Driver.Rules, rule{
+						ID:                   id,
+						ShortDescription:     message{Text: description},
+						DefaultConfiguration: configuration{Level: level},
+					})
+				}
+				r.Results = append(r.Results, result{
+					RuleID:    id,
+					RuleIndex: ruleIndex[id],
+					Level:     d.Severity,
+					Message:   message{Text: d.Message},
+					Locations: []location{{PhysicalLocation: physicalLocation{
+						ArtifactLocation: artifactLocation{URI: uri, URIBaseID: "SRCROOT"},
+						Region:           region{StartLine: d.Line, StartColumn: d.Column},
+					}}},
+				})
+			}
+		}
+		l.Runs = append(l.Runs, r)
+	}
+	return json.MarshalIndent(l, "", "  ")
+}
+
+// Write writes the SARIF log of the tests to a file.
+func Write(file string, libPath string, tests []test.TestResult) error {
+	b, err := Generate(libPath, tests)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(file, b, 0644)
+}
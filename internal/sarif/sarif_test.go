@@ -0,0 +1,96 @@
+package sarif
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// The logs in testdata were produced by GCC with -Wall -Wextra, with the
+// paths rewritten to the ones of an installed library.
+const libPath = "/home/user/Arduino/libraries/Foo"
+
+func readLog(t *testing.T, name string) string {
+	b, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []test.TestResult{{
+		Version:     "1.0.0",
+		FQBN:        "arduino:avr:uno",
+		Core:        "arduino:avr",
+		CoreVersion: "1.8.5",
+		Result:      test.FAIL,
+		Log:         readLog(t, "inclusion.log"),
+		Examples: []test.ExampleResult{
+			{Name: "Blink", Result: test.FAIL, Log: readLog(t, "example.log")},
+		},
+	}}
+	b, err := Generate(libPath, tests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l log
+	if err := json.Unmarshal(b, &l); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Runs) != 1 {
+		t.Fatalf("got %d runs, want 1", len(l.Runs))
+	}
+	r := l.Runs[0]
+	if r.AutomationDetails.ID != "arduino:avr:uno/" {
+		t.Errorf("automation id = %q", r.AutomationDetails.ID)
+	}
+
+	// The warning of the core and the note are not reported, and the
+	// diagnostics of Foo.cpp found by both compilations are reported once
+	want := []struct {
+		rule, level, uri string
+		line, column     int
+	}{
+		{"parentheses", "warning", "src/Foo.cpp", 4, 11},
+		{"undeclared-identifier", "error", "src/Foo.cpp", 5, 12},
+		{"unused-variable", "warning", "src/Foo.cpp", 3, 7},
+		{"error", "error", "src/Bar.cpp", 2, 13},
+		{"unused-variable", "warning", "examples/Blink/Blink.ino", 2, 7},
+	}
+	if len(r.Results) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(r.Results), len(want), r.Results)
+	}
+	for i, w := range want {
+		res := r.Results[i]
+		loc := res.Locations[0].PhysicalLocation
+		if res.RuleID != w.rule || res.Level != w.level || loc.ArtifactLocation.URI != w.uri ||
+			loc.Region.StartLine != w.line || loc.Region.StartColumn != w.column {
+			t.Errorf("result %d = %s %s %s:%d:%d, want %s %s %s:%d:%d", i,
+				res.RuleID, res.Level, loc.ArtifactLocation.URI, loc.Region.StartLine, loc.Region.StartColumn,
+				w.rule, w.level, w.uri, w.line, w.column)
+		}
+		if rule := r.Tool.Driver.Rules[res.RuleIndex]; rule.ID != res.RuleID {
+			t.Errorf("result %d references rule %s, want %s", i, rule.ID, res.RuleID)
+		}
+	}
+	if len(r.Tool.Driver.Rules) != 4 {
+		t.Errorf("got %d rules, want 4", len(r.Tool.Driver.Rules))
+	}
+}
+
+func TestGenerateNoTests(t *testing.T) {
+	b, err := Generate(libPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l log
+	if err := json.Unmarshal(b, &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Version != "2.1.0" || l.Runs == nil || len(l.Runs) != 0 {
+		t.Errorf("unexpected log: %s", b)
+	}
+}
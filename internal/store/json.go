@@ -0,0 +1,99 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+	"github.com/arduino/arduino-cli/arduino/utils"
+)
+
+// jsonStore keeps the results of each library in a separate JSON file, and
+// the logs in the logs directory.
+type jsonStore struct {
+	path string
+	logs logDir
+}
+
+func openJSON(datadirPath string) (*jsonStore, error) {
+	if err := os.MkdirAll(datadirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &jsonStore{path: datadirPath, logs: logDir{path.Join(datadirPath, "logs")}}, nil
+}
+
+func (s *jsonStore) file(lib string) string {
+	return path.Join(s.path, utils.SanitizeName(lib)+".json")
+}
+
+// Get reads the results file of a library. Corrupt files are quarantined, and
+// an error wrapping test.ErrCorruptResults is returned along with empty
+// results.
+func (s *jsonStore) Get(lib string) (test.TestResults, error) {
+	tr, err := readResultsFile(s.file(lib))
+	if os.IsNotExist(err) {
+		return tr, nil
+	}
+	return tr, err
+}
+
+// readResultsFile reads a results file, quarantining it if it's corrupt.
+func readResultsFile(file string) (test.TestResults, error) {
+	var tr test.TestResults
+	err := test.LoadResultsFile(file, &tr)
+	if errors.Is(err, test.ErrCorruptResults) {
+		dest, qErr := test.QuarantineResultsFile(file)
+		if os.IsNotExist(qErr) {
+			// Quarantined by a concurrent reader
+			return tr, err
+		} else if qErr != nil {
+			return tr, fmt.Errorf("could not quarantine corrupt results file: %v", qErr)
+		}
+		return tr, fmt.Errorf("%w; moved to %s", err, dest)
+	}
+	return tr, err
+}
+
+func (s *jsonStore) Put(tr test.TestResults) error {
+	tr, err := storeLogs(tr, s.logs.put)
+	if err != nil {
+		return err
+	}
+	return test.WriteResultsFile(s.file(tr.Name), tr)
+}
+
+func (s *jsonStore) Log(hash string) (string, error) {
+	return s.logs.get(hash)
+}
+
+func (s *jsonStore) PruneLogs(referenced map[string]bool) (int, error) {
+	return s.logs.prune(referenced)
+}
+
+// Walk reads all the results files; the ones that can't be read are skipped
+// with a warning, and the corrupt ones are quarantined.
+func (s *jsonStore) Walk(fn func(tr test.TestResults) error) error {
+	files, err := test.ResultsFiles(s.path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		tr, err := readResultsFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %v\n", err)
+			continue
+		}
+		if err := fn(tr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jsonStore) Close() error {
+	return nil
+}
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// logDir stores compilation logs as gzip files named after the SHA-256 of
+// their content, so that identical logs are stored only once.
+type logDir struct {
+	path string
+}
+
+func hashLog(log string) string {
+	sum := sha256.Sum256([]byte(log))
+	return hex.EncodeToString(sum[:])
+}
+
+func compressLog(log string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(log)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressLog(data []byte) (string, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	log, err := ioutil.ReadAll(r)
+	return string(log), err
+}
+
+func (d logDir) file(hash string) string {
+	return path.Join(d.path, hash[:2], hash+".gz")
+}
+
+func (d logDir) put(log string) (string, error) {
+	hash := hashLog(log)
+	file := d.file(hash)
+	if _, err := os.Stat(file); err == nil {
+		return hash, nil
+	}
+	data, err := compressLog(log)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		return "", err
+	}
+	return hash, util.WriteFileAtomic(file, data, 0644)
+}
+
+func (d logDir) get(hash string) (string, error) {
+	data, err := ioutil.ReadFile(d.file(hash))
+	if err != nil {
+		return "", err
+	}
+	return decompressLog(data)
+}
+
+func (d logDir) prune(referenced map[string]bool) (int, error) {
+	files, err := filepath.Glob(path.Join(d.path, "*", "*.gz"))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, file := range files {
+		if referenced[strings.TrimSuffix(path.Base(file), ".gz")] {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// storeLogs returns a copy of the results where the logs are replaced by
+// references to the logs saved with put.
+func storeLogs(tr test.TestResults, put func(log string) (string, error)) (test.TestResults, error) {
+	var err error
+	tests := make([]test.TestResult, len(tr.Tests))
+	for i, t := range tr.Tests {
+		if t.Log != "" {
+			if t.LogHash, err = put(t.Log); err != nil {
+				return tr, err
+			}
+			t.Log = ""
+		}
+		examples := make([]test.ExampleResult, len(t.Examples))
+		for j, e := range t.Examples {
+			if e.Log != "" {
+				if e.LogHash, err = put(e.Log); err != nil {
+					return tr, err
+				}
+				e.Log = ""
+			}
+			examples[j] = e
+		}
+		t.Examples = examples
+		tests[i] = t
+	}
+	if tr.Tests != nil {
+		tr.Tests = tests
+	}
+	return tr, nil
+}
+
+// LoadLogs fills the logs of the tests and examples that reference a stored
+// log.
+func LoadLogs(s Store, tr *test.TestResults) error {
+	tests := make([]test.TestResult, len(tr.Tests))
+	for i, t := range tr.Tests {
+		if t.Log == "" && t.LogHash != "" {
+			log, err := s.Log(t.LogHash)
+			if err != nil {
+				return err
+			}
+			t.Log = log
+		}
+		examples := make([]test.ExampleResult, len(t.Examples))
+		for j, e := range t.Examples {
+			if e.Log == "" && e.LogHash != "" {
+				log, err := s.Log(e.LogHash)
+				if err != nil {
+					return err
+				}
+				e.Log = log
+			}
+			examples[j] = e
+		}
+		t.Examples = examples
+		tests[i] = t
+	}
+	if tr.Tests != nil {
+		tr.Tests = tests
+	}
+	return nil
+}
+
+// GCStats reports what was removed by GC.
+type GCStats struct {
+	DroppedLogs int // logs of passing builds no longer referenced
+	RemovedLogs int // stored logs removed
+}
+
+// GC removes the stored logs that are no longer referenced by any result. If
+// retention is not zero, the logs of passing builds older than retention are
+// dropped first; tests without a run timestamp are considered expired.
+func GC(s Store, retention time.Duration) (GCStats, error) {
+	var stats GCStats
+	referenced := make(map[string]bool)
+	expired := func(t test.TestResult) bool {
+		if retention == 0 {
+			return false
+		}
+		run, err := time.Parse(time.RFC3339, t.Run)
+		return err != nil || time.Since(run) > retention
+	}
+	err := s.Walk(func(tr test.TestResults) error {
+		changed := false
+		tests := make([]test.TestResult, len(tr.Tests))
+		for i, t := range tr.Tests {
+			if expired(t) {
+				if t.Result == test.PASS && (t.Log != "" || t.LogHash != "") {
+					t.Log, t.LogHash = "", ""
+					stats.DroppedLogs++
+					changed = true
+				}
+				examples := make([]test.ExampleResult, len(t.Examples))
+				for j, e := range t.Examples {
+					if e.Result == test.PASS && (e.Log != "" || e.LogHash != "") {
+						e.Log, e.LogHash = "", ""
+						stats.DroppedLogs++
+						changed = true
+					}
+					examples[j] = e
+				}
+				t.Examples = examples
+			}
+			// Logs still embedded in the results will be stored by Put
+			referenced[t.LogHash] = true
+			referenced[hashLog(t.Log)] = true
+			for _, e := range t.Examples {
+				referenced[e.LogHash] = true
+				referenced[hashLog(e.Log)] = true
+			}
+			tests[i] = t
+		}
+		if !changed {
+			return nil
+		}
+		tr.Tests = tests
+		return s.Put(tr)
+	})
+	if err != nil {
+		return stats, err
+	}
+	stats.RemovedLogs, err = s.PruneLogs(referenced)
+	return stats, err
+}
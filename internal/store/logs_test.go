@@ -0,0 +1,91 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+func TestStoreLogs(t *testing.T) {
+	tr := results()
+	stored := make(map[string]string)
+	got, err := storeLogs(tr, func(log string) (string, error) {
+		stored[hashLog(log)] = log
+		return hashLog(log), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 3 {
+		t.Errorf("stored %d logs, want 3", len(stored))
+	}
+	for _, tt := range got.Tests {
+		if tt.Log != "" || (tt.LogHash != "") != (stored[tt.LogHash] != "") {
+			t.Errorf("test on %s: log %q, hash %s", tt.FQBN, tt.Log, tt.LogHash)
+		}
+		for _, e := range tt.Examples {
+			if e.Log != "" || stored[e.LogHash] == "" {
+				t.Errorf("example %s: log %q, hash %s", e.Name, e.Log, e.LogHash)
+			}
+		}
+	}
+
+	// The results passed are not modified
+	if !reflect.DeepEqual(tr, results()) {
+		t.Errorf("storeLogs modified its argument")
+	}
+}
+
+func TestGC(t *testing.T) {
+	recent := time.Now().UTC().Format(time.RFC3339)
+	for _, kind := range kinds {
+		s := open(t, kind)
+
+		// A log no longer referenced
+		orphan := test.TestResults{Name: "Foo", Tests: []test.TestResult{{Version: "0.1.0", FQBN: "arduino:avr:uno", Core: "arduino:avr",
+			Architectures: []string{"avr"}, Result: test.PASS, Log: "orphan log\n", Examples: []test.ExampleResult{}}}}
+		if err := s.Put(orphan); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+
+		tr := results()
+		tr.Tests[2].Log = "passing log\n"
+		tr.Tests = append(tr.Tests, test.TestResult{Version: "1.0.0", FQBN: "arduino:avr:nano", Core: "arduino:avr", Architectures: []string{"avr"},
+			Result: test.PASS, Log: "recent log\n", Run: recent, Examples: []test.ExampleResult{}})
+		if err := s.Put(tr); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+
+		// Without a retention only the orphan is removed
+		stats, err := GC(s, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if stats != (GCStats{RemovedLogs: 1}) {
+			t.Errorf("%s: GC without retention = %+v", kind, stats)
+		}
+
+		// The logs of the old passing builds are dropped: the inclusion
+		// test on the mkr1000 and the Blink example
+		stats, err = GC(s, 24*time.Hour)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if stats != (GCStats{DroppedLogs: 2, RemovedLogs: 2}) {
+			t.Errorf("%s: GC with retention = %+v", kind, stats)
+		}
+		got, err := s.Get("Foo")
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if err := LoadLogs(s, &got); err != nil {
+			t.Fatalf("%s: failing and recent logs lost: %v", kind, err)
+		}
+		logs := []string{got.Tests[1].Log, got.Tests[1].Examples[0].Log, got.Tests[1].Examples[1].Log, got.Tests[2].Log, got.Tests[3].Log}
+		if want := []string{tr.Tests[1].Log, "", "Read log\n", "", "recent log\n"}; !reflect.DeepEqual(logs, want) {
+			t.Errorf("%s: logs after GC = %q, want %q", kind, logs, want)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/alranel/arduino-testlib/pkg/test"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteFile is the name of the database in the datadir.
+const SQLiteFile = "results.sqlite"
+
+// migrations bring the schema from each version to the next one. The version
+// of a database is stored in its user_version pragma.
+var migrations = []string{`
+CREATE TABLE libraries (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE COLLATE NOCASE
+);
+CREATE TABLE versions (
+	id INTEGER PRIMARY KEY,
+	library_id INTEGER NOT NULL REFERENCES libraries(id) ON DELETE CASCADE,
+	version TEXT NOT NULL,
+	architectures TEXT NOT NULL, -- comma-separated, as in library.properties
+	UNIQUE (library_id, version)
+);
+CREATE TABLE boards (
+	id INTEGER PRIMARY KEY,
+	fqbn TEXT NOT NULL UNIQUE,
+	core TEXT NOT NULL
+);
+CREATE TABLE runs (
+	id INTEGER PRIMARY KEY,
+	started_at TEXT NOT NULL UNIQUE
+);
+CREATE TABLE tests (
+	id INTEGER PRIMARY KEY,
+	version_id INTEGER NOT NULL REFERENCES versions(id) ON DELETE CASCADE,
+	board_id INTEGER NOT NULL REFERENCES boards(id),
+	core_version TEXT NOT NULL,
+	run_id INTEGER REFERENCES runs(id),
+	seq INTEGER NOT NULL, -- position in the results of the library
+	result TEXT NOT NULL,
+	log TEXT NOT NULL,
+	no_main_header INTEGER NOT NULL,
+	duration REAL NOT NULL
+);
+CREATE INDEX tests_version ON tests(version_id);
+CREATE INDEX tests_board ON tests(board_id);
+CREATE TABLE examples (
+	id INTEGER PRIMARY KEY,
+	test_id INTEGER NOT NULL REFERENCES tests(id) ON DELETE CASCADE,
+	seq INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	result TEXT NOT NULL,
+	log TEXT NOT NULL
+);
+CREATE INDEX examples_test ON examples(test_id);
+CREATE TABLE diagnostics (
+	id INTEGER PRIMARY KEY,
+	test_id INTEGER NOT NULL REFERENCES tests(id) ON DELETE CASCADE,
+	example_id INTEGER REFERENCES examples(id) ON DELETE CASCADE, -- NULL for the inclusion test
+	file TEXT NOT NULL,
+	line INTEGER NOT NULL,
+	col INTEGER NOT NULL,
+	severity TEXT NOT NULL,
+	message TEXT NOT NULL,
+	flag TEXT NOT NULL
+);
+CREATE INDEX diagnostics_test ON diagnostics(test_id);
+CREATE INDEX diagnostics_example ON diagnostics(example_id);
+`, `
+CREATE TABLE logs (
+	hash TEXT PRIMARY KEY, -- SHA-256 of the uncompressed log
+	data BLOB NOT NULL -- gzip-compressed
+);
+ALTER TABLE tests ADD COLUMN log_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE examples ADD COLUMN log_hash TEXT NOT NULL DEFAULT '';
+`, `
+CREATE TABLE used_libraries (
+	id INTEGER PRIMARY KEY,
+	test_id INTEGER NOT NULL REFERENCES tests(id) ON DELETE CASCADE,
+	example_id INTEGER REFERENCES examples(id) ON DELETE CASCADE, -- NULL for the inclusion test
+	name TEXT NOT NULL,
+	version TEXT NOT NULL
+);
+CREATE INDEX used_libraries_test ON used_libraries(test_id);
+CREATE INDEX used_libraries_name ON used_libraries(name);
+`, `
+ALTER TABLE tests ADD COLUMN cache_key TEXT NOT NULL DEFAULT '';
+`, `
+ALTER TABLE versions ADD COLUMN author TEXT NOT NULL DEFAULT '';
+ALTER TABLE versions ADD COLUMN maintainer TEXT NOT NULL DEFAULT '';
+ALTER TABLE versions ADD COLUMN sentence TEXT NOT NULL DEFAULT '';
+ALTER TABLE versions ADD COLUMN category TEXT NOT NULL DEFAULT '';
+ALTER TABLE versions ADD COLUMN url TEXT NOT NULL DEFAULT '';
+ALTER TABLE versions ADD COLUMN depends TEXT NOT NULL DEFAULT ''; -- comma-separated, as in library.properties
+ALTER TABLE versions ADD COLUMN includes TEXT NOT NULL DEFAULT ''; -- comma-separated
+ALTER TABLE versions ADD COLUMN has_license INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE versions ADD COLUMN layout TEXT NOT NULL DEFAULT ''; -- flat or src, empty if unknown
+`, `
+CREATE TABLE history (
+	library_id INTEGER NOT NULL REFERENCES libraries(id) ON DELETE CASCADE,
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	board_id INTEGER NOT NULL REFERENCES boards(id),
+	status TEXT NOT NULL, -- compatibility status
+	PRIMARY KEY (library_id, run_id, board_id)
+);
+`}
+
+// sqliteStore keeps all the results in a single SQLite database, which can
+// also be queried directly.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLite(datadirPath string) (*sqliteStore, error) {
+	dsn := "file:" + path.Join(datadirPath, SQLiteFile) + "?_foreign_keys=on&_busy_timeout=10000&_journal_mode=WAL"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serializes the writes of the testall workers
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %v", SQLiteFile, err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than the supported one (%d)", version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration to schema version %d failed: %v", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) Get(lib string) (test.TestResults, error) {
+	var id int64
+	err := s.db.QueryRow("SELECT id FROM libraries WHERE name = ?", lib).Scan(&id)
+	if err == sql.ErrNoRows {
+		return test.TestResults{}, nil
+	} else if err != nil {
+		return test.TestResults{}, err
+	}
+	return s.get(id)
+}
+
+func (s *sqliteStore) get(libraryID int64) (test.TestResults, error) {
+	var tr test.TestResults
+	if err := s.db.QueryRow("SELECT name FROM libraries WHERE id = ?", libraryID).Scan(&tr.Name); err != nil {
+		return tr, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT t.id, v.version, v.architectures, b.fqbn, b.core, t.core_version,
+			t.result, t.log, t.log_hash, t.no_main_header, t.duration, COALESCE(r.started_at, ''), t.cache_key,
+			v.author, v.maintainer, v.sentence, v.category, v.url, v.depends, v.includes, v.has_license, v.layout
+		FROM tests t
+		JOIN versions v ON v.id = t.version_id
+		JOIN boards b ON b.id = t.board_id
+		LEFT JOIN runs r ON r.id = t.run_id
+		WHERE v.library_id = ?
+		ORDER BY t.seq`, libraryID)
+	if err != nil {
+		return tr, err
+	}
+	testIndex := make(map[int64]int) // test id => index in tr.Tests
+	for rows.Next() {
+		var id int64
+		var architectures, depends, includes string
+		t := test.TestResult{Examples: []test.ExampleResult{}}
+		if err := rows.Scan(&id, &t.Version, &architectures, &t.FQBN, &t.Core, &t.CoreVersion,
+			&t.Result, &t.Log, &t.LogHash, &t.NoMainHeader, &t.Duration, &t.Run, &t.CacheKey,
+			&t.Author, &t.Maintainer, &t.Sentence, &t.Category, &t.URL, &depends, &includes, &t.HasLicense, &t.Layout); err != nil {
+			rows.Close()
+			return tr, err
+		}
+		t.Architectures = strings.Split(architectures, ",")
+		if depends != "" {
+			t.Depends = strings.Split(depends, ",")
+		}
+		if includes != "" {
+			t.Includes = strings.Split(includes, ",")
+		}
+		testIndex[id] = len(tr.Tests)
+		tr.Tests = append(tr.Tests, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return tr, err
+	}
+
+	rows, err = s.db.Query(`
+		SELECT e.id, e.test_id, e.name, e.result, e.log, e.log_hash
+		FROM examples e
+		JOIN tests t ON t.id = e.test_id
+		JOIN versions v ON v.id = t.version_id
+		WHERE v.library_id = ?
+		ORDER BY e.test_id, e.seq`, libraryID)
+	if err != nil {
+		return tr, err
+	}
+	exampleIndex := make(map[int64]int) // example id => index in the examples of its test
+	for rows.Next() {
+		var id, testID int64
+		var e test.ExampleResult
+		if err := rows.Scan(&id, &testID, &e.Name, &e.Result, &e.Log, &e.LogHash); err != nil {
+			rows.Close()
+			return tr, err
+		}
+		t := &tr.Tests[testIndex[testID]]
+		exampleIndex[id] = len(t.Examples)
+		t.Examples = append(t.Examples, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return tr, err
+	}
+
+	rows, err = s.db.Query(`
+		SELECT u.test_id, u.example_id, u.name, u.version
+		FROM used_libraries u
+		JOIN tests t ON t.id = u.test_id
+		JOIN versions v ON v.id = t.version_id
+		WHERE v.library_id = ?`, libraryID)
+	if err != nil {
+		return tr, err
+	}
+	for rows.Next() {
+		var testID int64
+		var exampleID sql.NullInt64
+		var name, version string
+		if err := rows.Scan(&testID, &exampleID, &name, &version); err != nil {
+			rows.Close()
+			return tr, err
+		}
+		t := &tr.Tests[testIndex[testID]]
+		used := &t.UsedLibraries
+		if exampleID.Valid {
+			used = &t.Examples[exampleIndex[exampleID.Int64]].UsedLibraries
+		}
+		if *used == nil {
+			*used = make(map[string]string)
+		}
+		(*used)[name] = version
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return tr, err
+	}
+
+	rows, err = s.db.Query(`
+		SELECT r.started_at, b.fqbn, h.status
+		FROM history h
+		JOIN runs r ON r.id = h.run_id
+		JOIN boards b ON b.id = h.board_id
+		WHERE h.library_id = ?
+		ORDER BY r.started_at, b.fqbn`, libraryID)
+	if err != nil {
+		return tr, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e test.HistoryEntry
+		if err := rows.Scan(&e.Run, &e.FQBN, &e.Status); err != nil {
+			return tr, err
+		}
+		tr.History = append(tr.History, e)
+	}
+	return tr, rows.Err()
+}
+
+// Put replaces all the tests of the library in a single transaction. Logs
+// are moved to the logs table, and the diagnostics are extracted from them.
+func (s *sqliteStore) Put(tr test.TestResults) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var libraryID int64
+	if err := tx.QueryRow(`INSERT INTO libraries (name) VALUES (?)
+		ON CONFLICT (name) DO UPDATE SET name = excluded.name RETURNING id`, tr.Name).Scan(&libraryID); err != nil {
+		return err
+	}
+	// Tests, examples and diagnostics are deleted in cascade
+	if _, err := tx.Exec("DELETE FROM versions WHERE library_id = ?", libraryID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM history WHERE library_id = ?", libraryID); err != nil {
+		return err
+	}
+	for _, h := range tr.History {
+		var runID, boardID int64
+		if err := tx.QueryRow(`INSERT INTO runs (started_at) VALUES (?)
+			ON CONFLICT (started_at) DO UPDATE SET started_at = excluded.started_at RETURNING id`, h.Run).Scan(&runID); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(`INSERT INTO boards (fqbn, core) VALUES (?, ?)
+			ON CONFLICT (fqbn) DO UPDATE SET fqbn = excluded.fqbn RETURNING id`, h.FQBN, util.CoreFromFQBN(h.FQBN)).Scan(&boardID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO history (library_id, run_id, board_id, status) VALUES (?, ?, ?, ?)",
+			libraryID, runID, boardID, h.Status); err != nil {
+			return err
+		}
+	}
+
+	for seq, t := range tr.Tests {
+		var versionID, boardID int64
+		var runID sql.NullInt64
+		if err := tx.QueryRow(`INSERT INTO versions (library_id, version, architectures) VALUES (?, ?, ?)
+			ON CONFLICT (library_id, version) DO UPDATE SET architectures = excluded.architectures RETURNING id`,
+			libraryID, t.Version, strings.Join(t.Architectures, ",")).Scan(&versionID); err != nil {
+			return err
+		}
+		// The versions were recreated above with empty metadata: set it from
+		// the tests recording it, skipping the ones recorded without it by
+		// older versions of this tool
+		if t.Layout != "" {
+			if _, err := tx.Exec(`UPDATE versions SET author = ?, maintainer = ?, sentence = ?, category = ?, url = ?,
+				depends = ?, includes = ?, has_license = ?, layout = ? WHERE id = ?`,
+				t.Author, t.Maintainer, t.Sentence, t.Category, t.URL,
+				strings.Join(t.Depends, ","), strings.Join(t.Includes, ","), t.HasLicense, t.Layout, versionID); err != nil {
+				return err
+			}
+		}
+		if err := tx.QueryRow(`INSERT INTO boards (fqbn, core) VALUES (?, ?)
+			ON CONFLICT (fqbn) DO UPDATE SET core = excluded.core RETURNING id`, t.FQBN, t.Core).Scan(&boardID); err != nil {
+			return err
+		}
+		if t.Run != "" {
+			if err := tx.QueryRow(`INSERT INTO runs (started_at) VALUES (?)
+				ON CONFLICT (started_at) DO UPDATE SET started_at = excluded.started_at RETURNING id`, t.Run).Scan(&runID); err != nil {
+				return err
+			}
+		}
+
+		log, logHash, err := putLog(tx, t.Log, t.LogHash)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(`INSERT INTO tests (version_id, board_id, core_version, run_id, seq, result, log, log_hash, no_main_header, duration, cache_key)
+			VALUES (?, ?, ?, ?, ?, ?, '', ?, ?, ?, ?)`,
+			versionID, boardID, t.CoreVersion, runID, seq, t.Result, logHash, t.NoMainHeader, t.Duration, t.CacheKey)
+		if err != nil {
+			return err
+		}
+		testID, _ := res.LastInsertId()
+		if err := insertDiagnostics(tx, testID, sql.NullInt64{}, log); err != nil {
+			return err
+		}
+		if err := insertUsedLibraries(tx, testID, sql.NullInt64{}, t.UsedLibraries); err != nil {
+			return err
+		}
+
+		for eSeq, e := range t.Examples {
+			log, logHash, err := putLog(tx, e.Log, e.LogHash)
+			if err != nil {
+				return err
+			}
+			res, err := tx.Exec("INSERT INTO examples (test_id, seq, name, result, log, log_hash) VALUES (?, ?, ?, ?, '', ?)",
+				testID, eSeq, e.Name, e.Result, logHash)
+			if err != nil {
+				return err
+			}
+			exampleID, _ := res.LastInsertId()
+			if err := insertDiagnostics(tx, testID, sql.NullInt64{Int64: exampleID, Valid: true}, log); err != nil {
+				return err
+			}
+			if err := insertUsedLibraries(tx, testID, sql.NullInt64{Int64: exampleID, Valid: true}, e.UsedLibraries); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// putLog stores a log in the logs table, returning its hash. When only the
+// hash is known, the stored log is returned instead, as it's still needed
+// to extract the diagnostics.
+func putLog(tx *sql.Tx, log string, hash string) (string, string, error) {
+	if log == "" {
+		if hash == "" {
+			return "", "", nil
+		}
+		var data []byte
+		if err := tx.QueryRow("SELECT data FROM logs WHERE hash = ?", hash).Scan(&data); err != nil {
+			return "", "", fmt.Errorf("log %s: %v", hash, err)
+		}
+		log, err := decompressLog(data)
+		return log, hash, err
+	}
+	hash = hashLog(log)
+	data, err := compressLog(log)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = tx.Exec("INSERT INTO logs (hash, data) VALUES (?, ?) ON CONFLICT (hash) DO NOTHING", hash, data)
+	return log, hash, err
+}
+
+func (s *sqliteStore) Log(hash string) (string, error) {
+	var data []byte
+	if err := s.db.QueryRow("SELECT data FROM logs WHERE hash = ?", hash).Scan(&data); err != nil {
+		return "", fmt.Errorf("log %s: %v", hash, err)
+	}
+	return decompressLog(data)
+}
+
+// PruneLogs also reclaims the space freed in the database.
+func (s *sqliteStore) PruneLogs(referenced map[string]bool) (int, error) {
+	rows, err := s.db.Query("SELECT hash FROM logs")
+	if err != nil {
+		return 0, err
+	}
+	var unreferenced []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !referenced[hash] {
+			unreferenced = append(unreferenced, hash)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, hash := range unreferenced {
+		if _, err := s.db.Exec("DELETE FROM logs WHERE hash = ?", hash); err != nil {
+			return 0, err
+		}
+	}
+	if len(unreferenced) > 0 {
+		if _, err := s.db.Exec("VACUUM"); err != nil {
+			return len(unreferenced), err
+		}
+	}
+	return len(unreferenced), nil
+}
+
+func insertDiagnostics(tx *sql.Tx, testID int64, exampleID sql.NullInt64, log string) error {
+	for _, d := range test.ParseDiagnostics(log) {
+		if _, err := tx.Exec(`INSERT INTO diagnostics (test_id, example_id, file, line, col, severity, message, flag)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			testID, exampleID, d.File, d.Line, d.Column, d.Severity, d.Message, d.Flag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertUsedLibraries(tx *sql.Tx, testID int64, exampleID sql.NullInt64, used map[string]string) error {
+	for name, version := range used {
+		if _, err := tx.Exec("INSERT INTO used_libraries (test_id, example_id, name, version) VALUES (?, ?, ?, ?)",
+			testID, exampleID, name, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) Walk(fn func(tr test.TestResults) error) error {
+	// Collect the ids first, since the single connection can't be shared
+	// with the queries run by get
+	rows, err := s.db.Query("SELECT id FROM libraries ORDER BY name")
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		tr, err := s.get(id)
+		if err != nil {
+			return err
+		}
+		if err := fn(tr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+// Store persists the test results of each library in a datadir.
+type Store interface {
+	// Get returns the results of a library. A library that was never tested
+	// yields empty results and no error.
+	Get(lib string) (test.TestResults, error)
+
+	// Put replaces the results of a library. Logs are stored separately, and
+	// the results only keep a reference to them.
+	Put(tr test.TestResults) error
+
+	// Log returns a log stored by Put.
+	Log(hash string) (string, error)
+
+	// PruneLogs removes the stored logs whose hash is not referenced,
+	// returning how many were removed.
+	PruneLogs(referenced map[string]bool) (int, error)
+
+	// Walk calls fn with the results of each library.
+	Walk(fn func(tr test.TestResults) error) error
+
+	Close() error
+}
+
+// Open opens the store of the given kind in a datadir, creating it if needed.
+func Open(kind string, datadirPath string) (Store, error) {
+	switch kind {
+	case "json", "":
+		return openJSON(datadirPath)
+	case "sqlite":
+		return openSQLite(datadirPath)
+	}
+	return nil, fmt.Errorf("unknown store: %s", kind)
+}
+
+// Detect returns the kind of the store in an existing datadir: sqlite if it
+// holds a database, json otherwise.
+func Detect(datadirPath string) string {
+	if _, err := os.Stat(path.Join(datadirPath, SQLiteFile)); err == nil {
+		return "sqlite"
+	}
+	return "json"
+}
+
+// Existing returns the kind of the store holding results in a datadir, or an
+// empty string if it holds none yet.
+func Existing(datadirPath string) string {
+	if _, err := os.Stat(path.Join(datadirPath, SQLiteFile)); err == nil {
+		return "sqlite"
+	}
+	if files, _ := test.ResultsFiles(datadirPath); len(files) > 0 {
+		return "json"
+	}
+	if _, err := os.Stat(path.Join(datadirPath, "logs")); err == nil {
+		return "json"
+	}
+	return ""
+}
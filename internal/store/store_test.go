@@ -0,0 +1,133 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+var kinds = []string{"json", "sqlite"}
+
+func open(t *testing.T, kind string) Store {
+	s, err := Open(kind, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// results returns the results of a library tested on two versions, the
+// oldest one recorded without metadata and without a run.
+func results() test.TestResults {
+	m := test.Metadata{Author: "Jane Doe", Maintainer: "Jane Doe", Sentence: "A library.", Category: "Sensors", URL: "https://example.com",
+		Depends: []string{"Wire", "SPI"}, Includes: []string{"Foo.h"}, HasLicense: true, Layout: test.LayoutSrc}
+	return test.TestResults{
+		Name: "Foo",
+		Tests: []test.TestResult{
+			{Version: "0.9.0", FQBN: "arduino:avr:uno", Core: "arduino:avr", CoreVersion: "1.8.4", Architectures: []string{"avr"},
+				Result: test.PASS, Examples: []test.ExampleResult{}, Duration: 1},
+			{Version: "1.0.0", FQBN: "arduino:avr:uno", Core: "arduino:avr", CoreVersion: "1.8.5", Architectures: []string{"avr", "samd"},
+				Result: test.FAIL, Log: "Foo.cpp:1:1: error: 'x' was not declared in this scope\n", Run: "2022-05-02T00:00:00Z",
+				CacheKey: "abc", Duration: 2.5, UsedLibraries: map[string]string{"Wire": "1.0"}, Metadata: m,
+				Examples: []test.ExampleResult{
+					{Name: "Blink", Result: test.PASS, Log: "Blink log\n", UsedLibraries: map[string]string{"Wire": "1.0", "SPI": "1.0"}},
+					{Name: "Read", Result: test.FAIL, Log: "Read log\n"},
+				}},
+			{Version: "1.0.0", FQBN: "arduino:samd:mkr1000", Core: "arduino:samd", CoreVersion: "1.8.13", Architectures: []string{"avr", "samd"},
+				Result: test.PASS, NoMainHeader: true, Run: "2022-05-02T00:00:00Z", Metadata: m, Examples: []test.ExampleResult{}},
+		},
+		History: []test.HistoryEntry{
+			{Run: "2022-05-01T00:00:00Z", FQBN: "arduino:avr:uno", Status: test.PASS_CLAIM},
+			{Run: "2022-05-02T00:00:00Z", FQBN: "arduino:avr:uno", Status: test.FAIL_CLAIM},
+			{Run: "2022-05-02T00:00:00Z", FQBN: "arduino:samd:mkr1000", Status: test.PASS_CLAIM},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, kind := range kinds {
+		s := open(t, kind)
+		want := results()
+		if err := s.Put(want); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		got, err := s.Get("Foo")
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+
+		// Logs are only referenced by the results
+		for _, tr := range got.Tests {
+			for _, e := range append([]test.ExampleResult{{Log: tr.Log}}, tr.Examples...) {
+				if e.Log != "" {
+					t.Errorf("%s: log stored in the results", kind)
+				}
+			}
+		}
+		if err := LoadLogs(s, &got); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		want.Tests[1].LogHash = hashLog(want.Tests[1].Log)
+		for i := range want.Tests[1].Examples {
+			e := &want.Tests[1].Examples[i]
+			e.LogHash = hashLog(e.Log)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Get = %+v\nwant %+v", kind, got, want)
+		}
+
+		if tr, err := s.Get("Bar"); err != nil || tr.Name != "" || len(tr.Tests) != 0 {
+			t.Errorf("%s: Get of a library never tested = %+v, %v", kind, tr, err)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	for _, kind := range kinds {
+		s := open(t, kind)
+		for _, name := range []string{"Foo", "Bar"} {
+			tr := results()
+			tr.Name = name
+			if err := s.Put(tr); err != nil {
+				t.Fatalf("%s: %v", kind, err)
+			}
+		}
+		var names []string
+		err := s.Walk(func(tr test.TestResults) error {
+			names = append(names, tr.Name)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if !reflect.DeepEqual(names, []string{"Bar", "Foo"}) {
+			t.Errorf("%s: walked %v", kind, names)
+		}
+	}
+}
+
+func TestExisting(t *testing.T) {
+	dir := t.TempDir()
+	if kind := Existing(dir); kind != "" {
+		t.Errorf("empty datadir holds a %s store", kind)
+	}
+	for _, kind := range kinds {
+		dir := t.TempDir()
+		s, err := Open(kind, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(results()); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		s.Close()
+		if got := Existing(dir); got != kind {
+			t.Errorf("Existing = %q, want %q", got, kind)
+		}
+		if got := Detect(dir); got != kind {
+			t.Errorf("Detect = %q, want %q", got, kind)
+		}
+	}
+}
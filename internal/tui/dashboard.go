@@ -0,0 +1,619 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alranel/arduino-testlib/internal/events"
+	"github.com/alranel/arduino-testlib/internal/util"
+	"github.com/alranel/arduino-testlib/pkg/test"
+)
+
+const (
+	ansiRed     = "\033[31m"
+	ansiGreen   = "\033[32m"
+	ansiBold    = "\033[1m"
+	ansiDim     = "\033[2m"
+	ansiReverse = "\033[7m"
+	ansiReset   = "\033[0m"
+
+	// maxFailures is how many failures are kept for the list
+	maxFailures = 1000
+)
+
+type worker struct {
+	library string
+	version string
+	fqbn    string
+	example string
+	since   time.Time // when the library was started
+}
+
+type failure struct {
+	library string
+	version string
+	fqbn    string
+	example string
+	error   string
+	log     string
+}
+
+type boardCount struct {
+	pass int
+	fail int
+}
+
+// Dashboard is a full-screen view of a testall run, fed by its events. It
+// shows the libraries in progress, the throughput, the expected time left,
+// the results of each board and the failures, whose logs can be opened.
+type Dashboard struct {
+	in  *os.File
+	out *os.File
+
+	libs     []string
+	fqbns    []string
+	workers  int
+	expected map[string]time.Duration // lib => historical duration
+	run      string
+
+	mu       sync.Mutex
+	started  time.Time
+	state    map[int]*worker
+	finished map[string]time.Duration // lib => actual duration
+	compiles int
+	boards   map[string]*boardCount
+	failures []failure // oldest first
+	selected int       // index in failures, -1 to follow the newest
+	viewing  *failure  // the failure whose log is open
+	offset   int       // first line of the log shown
+	status   string
+
+	drawMu   sync.Mutex // serializes the drawing by the ticker and keypresses
+	restore  func()
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates a dashboard for testing libs on the fqbns with the given
+// number of workers. expected holds the historical test duration of the
+// libraries, used to estimate the time left.
+func New(libs []string, fqbns []string, workers int, expected map[string]time.Duration) *Dashboard {
+	d := &Dashboard{
+		in:       os.Stdin,
+		out:      os.Stdout,
+		libs:     libs,
+		fqbns:    fqbns,
+		workers:  workers,
+		expected: expected,
+		started:  time.Now(),
+		state:    make(map[int]*worker),
+		finished: make(map[string]time.Duration),
+		boards:   make(map[string]*boardCount),
+		selected: -1,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	for _, fqbn := range fqbns {
+		d.boards[fqbn] = &boardCount{}
+	}
+	return d
+}
+
+// Start switches the terminal to full screen and starts drawing. It fails
+// if stdin and stdout are not a terminal supporting it.
+func (d *Dashboard) Start() error {
+	if !util.IsTerminal(d.in) || !util.IsTerminal(d.out) {
+		return fmt.Errorf("not a terminal")
+	}
+	restore, err := util.RawInput(d.in)
+	if err != nil {
+		return err
+	}
+	d.restore = restore
+
+	// Use the alternate screen and hide the cursor
+	fmt.Fprint(d.out, "\033[?1049h\033[?25l")
+	go d.readKeys()
+	go func() {
+		defer close(d.stopped)
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			d.draw()
+			select {
+			case <-ticker.C:
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop restores the terminal. It can be called multiple times, and on a nil
+// dashboard.
+func (d *Dashboard) Stop() {
+	if d == nil || d.restore == nil {
+		return
+	}
+	d.stopOnce.Do(func() {
+		close(d.stop)
+		<-d.stopped
+		fmt.Fprint(d.out, "\033[?25h\033[?1049l")
+		d.restore()
+	})
+}
+
+// SetStatus shows a message in the title bar.
+func (d *Dashboard) SetStatus(status string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.status = status
+	d.mu.Unlock()
+}
+
+// Handle updates the dashboard with an event of the run.
+func (d *Dashboard) Handle(e events.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w := d.worker(e)
+	switch e.Type {
+	case events.RunStarted:
+		d.run = e.Run
+	case events.LibraryStarted:
+		if w != nil {
+			*w = worker{library: e.Library, since: e.Time}
+		}
+	case events.CompileStarted:
+		if w != nil {
+			w.version, w.fqbn, w.example = e.Version, e.FQBN, e.Example
+		}
+	case events.CompileFinished:
+		d.compiles++
+		if e.Example == "" {
+			b, ok := d.boards[e.FQBN]
+			if !ok {
+				b = &boardCount{}
+				d.boards[e.FQBN] = b
+			}
+			if e.Result == test.PASS {
+				b.pass++
+			} else {
+				b.fail++
+			}
+		}
+		if e.Result == test.FAIL {
+			d.failures = append(d.failures, failure{
+				library: e.Library,
+				version: e.Version,
+				fqbn:    e.FQBN,
+				example: e.Example,
+				error:   e.Error,
+				log:     e.Log,
+			})
+			if len(d.failures) > maxFailures {
+				d.failures = d.failures[1:]
+				if d.selected > 0 {
+					d.selected--
+				}
+			}
+		}
+	case events.LibraryFinished:
+		d.finished[e.Library] = time.Duration(e.Duration * float64(time.Second))
+		if w != nil {
+			*w = worker{}
+		}
+	case events.RunFinished:
+		d.status = "finished"
+	}
+}
+
+func (d *Dashboard) worker(e events.Event) *worker {
+	if e.Worker == nil {
+		return nil
+	}
+	w, ok := d.state[*e.Worker]
+	if !ok {
+		w = &worker{}
+		d.state[*e.Worker] = w
+	}
+	return w
+}
+
+// eta estimates the time left from the historical durations of the
+// libraries still to test, corrected by how the libraries already tested
+// compared with their history. Libraries never tested count as the average.
+func (d *Dashboard) eta() (time.Duration, bool) {
+	var known time.Duration
+	for _, lib := range d.libs {
+		known += d.expected[lib]
+	}
+	var average time.Duration
+	switch {
+	case len(d.expected) > 0:
+		average = known / time.Duration(len(d.expected))
+	case len(d.finished) > 0:
+		var actual time.Duration
+		for _, dur := range d.finished {
+			actual += dur
+		}
+		average = actual / time.Duration(len(d.finished))
+	default:
+		return 0, false
+	}
+
+	var expectedDone, actualDone, remaining time.Duration
+	inProgress := make(map[string]time.Time)
+	for _, w := range d.state {
+		if w.library != "" {
+			inProgress[w.library] = w.since
+		}
+	}
+	for _, lib := range d.libs {
+		expected, ok := d.expected[lib]
+		if !ok {
+			expected = average
+		}
+		if actual, ok := d.finished[lib]; ok {
+			expectedDone += expected
+			actualDone += actual
+			continue
+		}
+		if since, ok := inProgress[lib]; ok {
+			expected -= time.Since(since)
+			if expected < 0 {
+				expected = 0
+			}
+		}
+		remaining += expected
+	}
+	ratio := 1.0
+	if expectedDone > 0 {
+		ratio = float64(actualDone) / float64(expectedDone)
+	}
+	workers := d.workers
+	if workers < 1 {
+		workers = 1
+	}
+	return time.Duration(float64(remaining) * ratio / float64(workers)), true
+}
+
+// segment is a piece of a line with an optional ANSI colour.
+type segment struct {
+	text  string
+	color string
+}
+
+type line []segment
+
+func plain(format string, a ...interface{}) line {
+	return line{{text: fmt.Sprintf(format, a...)}}
+}
+
+func colored(color string, format string, a ...interface{}) line {
+	return line{{text: fmt.Sprintf(format, a...), color: color}}
+}
+
+// render returns the line cut to width columns.
+func (l line) render(width int) string {
+	var b strings.Builder
+	for _, s := range l {
+		text := s.text
+		if n := utf8.RuneCountInString(text); n > width {
+			text = string([]rune(text)[:width])
+		}
+		width -= utf8.RuneCountInString(text)
+		if s.color != "" && text != "" {
+			b.WriteString(s.color + text + ansiReset)
+		} else {
+			b.WriteString(text)
+		}
+		if width <= 0 {
+			break
+		}
+	}
+	return b.String()
+}
+
+func pad(s string, n int) string {
+	if c := utf8.RuneCountInString(s); c < n {
+		return s + strings.Repeat(" ", n-c)
+	}
+	return s
+}
+
+func (d *Dashboard) draw() {
+	d.drawMu.Lock()
+	defer d.drawMu.Unlock()
+	width, height, err := util.TerminalSize(d.out)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	d.mu.Lock()
+	var lines []line
+	if d.viewing != nil {
+		lines = d.logView(width, height)
+	} else {
+		lines = d.mainView(height)
+	}
+	d.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i, l := range lines {
+		if i >= height {
+			break
+		}
+		b.WriteString(l.render(width))
+		b.WriteString("\033[K")
+		if i < height-1 && i < len(lines)-1 {
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("\033[J")
+	io.WriteString(d.out, b.String())
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
+
+func (d *Dashboard) mainView(height int) []line {
+	var lines []line
+	title := line{{text: "arduino-testlib testall", color: ansiBold}, {text: "  run " + d.run}}
+	if d.status != "" {
+		title = append(title, segment{text: "  " + d.status, color: ansiBold})
+	}
+	lines = append(lines, title)
+
+	elapsed := time.Since(d.started)
+	done := len(d.finished)
+	progress := plain("Libraries %d/%d", done, len(d.libs))
+	if len(d.libs) > 0 {
+		progress[0].text += fmt.Sprintf(" (%.1f%%)", float64(done)*100/float64(len(d.libs)))
+	}
+	progress[0].text += "  Elapsed " + formatDuration(elapsed)
+	if eta, ok := d.eta(); ok && done < len(d.libs) {
+		progress[0].text += "  ETA " + formatDuration(eta)
+	}
+	if minutes := elapsed.Minutes(); elapsed > 10*time.Second {
+		progress[0].text += fmt.Sprintf("  %.1f libs/min, %.1f compiles/min", float64(done)/minutes, float64(d.compiles)/minutes)
+	}
+	lines = append(lines, progress, nil)
+
+	// Workers
+	lines = append(lines, colored(ansiBold, "%-7s %-30s %-30s %s", "WORKER", "LIBRARY", "BOARD", "FOR"))
+	var ids []int
+	for id := range d.state {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		w := d.state[id]
+		if w.library == "" {
+			lines = append(lines, colored(ansiDim, "#%-6d idle", id))
+			continue
+		}
+		board := w.fqbn
+		if w.example != "" {
+			board += " " + w.example
+		}
+		lines = append(lines, plain("#%-6d %-30s %-30s %s", id, strings.TrimSpace(w.library+" "+w.version), board, formatDuration(time.Since(w.since))))
+	}
+	lines = append(lines, nil)
+
+	// Boards
+	boardWidth := 20
+	for fqbn := range d.boards {
+		if len(fqbn) > boardWidth {
+			boardWidth = len(fqbn)
+		}
+	}
+	var fqbns []string
+	fqbns = append(fqbns, d.fqbns...)
+	for fqbn := range d.boards {
+		found := false
+		for _, f := range d.fqbns {
+			found = found || f == fqbn
+		}
+		if !found {
+			fqbns = append(fqbns, fqbn)
+		}
+	}
+	lines = append(lines, colored(ansiBold, "%s %7s %7s", pad("BOARD", boardWidth), "PASS", "FAIL"))
+	for _, fqbn := range fqbns {
+		b := d.boards[fqbn]
+		lines = append(lines, line{
+			{text: pad(fqbn, boardWidth) + " "},
+			{text: fmt.Sprintf("%7d", b.pass), color: ansiGreen},
+			{text: " "},
+			{text: fmt.Sprintf("%7d", b.fail), color: ansiRed},
+		})
+	}
+	lines = append(lines, nil)
+
+	// Failures, the newest first
+	lines = append(lines, line{
+		{text: fmt.Sprintf("FAILURES (%d)", len(d.failures)), color: ansiBold},
+		{text: "  up/down: select, enter: view log, ctrl-c: stop the run", color: ansiDim},
+	})
+	rows := height - len(lines)
+	if rows < 1 || len(d.failures) == 0 {
+		return lines
+	}
+	selected := d.selectedFailure()
+	top := 0
+	if k := len(d.failures) - 1 - selected; k >= rows {
+		top = k - rows + 1
+	}
+	for k := top; k < len(d.failures) && k < top+rows; k++ {
+		i := len(d.failures) - 1 - k
+		f := d.failures[i]
+		text := fmt.Sprintf("%s  %s", strings.TrimSpace(f.library+" "+f.version), f.fqbn)
+		if f.example != "" {
+			text += "  " + f.example
+		}
+		text += "  " + f.error
+		if i == selected {
+			lines = append(lines, colored(ansiReverse, "> %s", text))
+		} else {
+			lines = append(lines, line{{text: "  "}, {text: text, color: ansiRed}})
+		}
+	}
+	return lines
+}
+
+func (d *Dashboard) selectedFailure() int {
+	if d.selected < 0 || d.selected >= len(d.failures) {
+		return len(d.failures) - 1
+	}
+	return d.selected
+}
+
+// logLines returns the log of the failure being viewed, wrapped to width.
+func (d *Dashboard) logLines(width int) []string {
+	var lines []string
+	for _, l := range strings.Split(strings.TrimRight(d.viewing.log, "\n"), "\n") {
+		l = strings.ReplaceAll(strings.TrimRight(l, "\r"), "\t", "    ")
+		r := []rune(l)
+		for len(r) > width {
+			lines = append(lines, string(r[:width]))
+			r = r[width:]
+		}
+		lines = append(lines, string(r))
+	}
+	return lines
+}
+
+func (d *Dashboard) logView(width int, height int) []line {
+	f := d.viewing
+	title := fmt.Sprintf("%s on %s", strings.TrimSpace(f.library+" "+f.version), f.fqbn)
+	if f.example != "" {
+		title += ", example " + f.example
+	}
+	lines := []line{
+		{{text: title, color: ansiBold}, {text: "  up/down/pgup/pgdn: scroll, q: back", color: ansiDim}},
+		nil,
+	}
+	log := d.logLines(width)
+	rows := height - len(lines)
+	d.clampOffset(len(log), rows)
+	for i := d.offset; i < len(log) && i < d.offset+rows; i++ {
+		lines = append(lines, plain("%s", log[i]))
+	}
+	return lines
+}
+
+func (d *Dashboard) clampOffset(n int, rows int) {
+	if d.offset > n-rows {
+		d.offset = n - rows
+	}
+	if d.offset < 0 {
+		d.offset = 0
+	}
+}
+
+// readKeys handles the keypresses until the dashboard is stopped.
+func (d *Dashboard) readKeys() {
+	buf := make([]byte, 64)
+	for {
+		n, err := d.in.Read(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case <-d.stop:
+			return
+		default:
+		}
+		d.mu.Lock()
+		d.key(string(buf[:n]))
+		d.mu.Unlock()
+		d.draw()
+	}
+}
+
+func (d *Dashboard) key(k string) {
+	_, height, err := util.TerminalSize(d.out)
+	if err != nil || height <= 0 {
+		height = 24
+	}
+	page := height - 3
+	if page < 1 {
+		page = 1
+	}
+
+	if d.viewing != nil {
+		switch k {
+		case "q", "\033":
+			d.viewing = nil
+		case "\033[A", "k":
+			d.offset--
+		case "\033[B", "j", "\r", "\n":
+			d.offset++
+		case "\033[5~", "b":
+			d.offset -= page
+		case "\033[6~", " ":
+			d.offset += page
+		case "g", "\033[H":
+			d.offset = 0
+		case "G", "\033[F":
+			// Clamped to the last page when drawing
+			d.offset = 1 << 30
+		}
+		if d.offset < 0 {
+			d.offset = 0
+		}
+		return
+	}
+
+	if len(d.failures) == 0 {
+		return
+	}
+	selected := d.selectedFailure()
+	switch k {
+	case "\033[A", "k":
+		// Newer failures are above
+		selected++
+	case "\033[B", "j":
+		selected--
+	case "\033[5~":
+		selected += page
+	case "\033[6~":
+		selected -= page
+	case "\r", "\n":
+		f := d.failures[selected]
+		d.viewing = &f
+		d.offset = 0
+		return
+	}
+	if selected < 0 {
+		selected = 0
+	}
+	if selected >= len(d.failures)-1 {
+		// Follow the newest failures again
+		selected = -1
+	}
+	d.selected = selected
+}
+
+// Summary prints the results of the run per board, for after the dashboard
+// is stopped.
+func (d *Dashboard) Summary(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(w, "Tested %d/%d libraries in %s, %d compilations\n", len(d.finished), len(d.libs), formatDuration(time.Since(d.started)), d.compiles)
+	for _, fqbn := range d.fqbns {
+		b := d.boards[fqbn]
+		fmt.Fprintf(w, "  %s: %d PASS, %d FAIL\n", fqbn, b.pass, b.fail)
+	}
+}
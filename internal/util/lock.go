@@ -0,0 +1,30 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path"
+)
+
+// ErrLocked is returned by LockDirectory when the lock is held by another
+// process.
+var ErrLocked = errors.New("directory is locked by another process")
+
+// LockDirectory acquires an advisory lock on a directory, using a hidden
+// lock file inside it. Multiple processes can hold a shared lock at the same
+// time, while an exclusive lock can only be held by one process. The call
+// doesn't block: ErrLocked is returned if the lock can't be acquired.
+func LockDirectory(dir string, exclusive bool) (unlock func(), err error) {
+	f, err := os.OpenFile(path.Join(dir, ".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
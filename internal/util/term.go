@@ -0,0 +1,59 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// IsTerminal checks whether f is a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// UseColor checks whether the output written to f can be coloured, which is
+// the case for terminals unless NO_COLOR is set.
+func UseColor(f *os.File) bool {
+	return IsTerminal(f) && os.Getenv("NO_COLOR") == ""
+}
+
+var (
+	exitHooks      []func()
+	exitHooksMutex sync.Mutex
+)
+
+// AtExit registers a function to be called by Exit, such as the restoration
+// of a terminal switched to raw mode.
+func AtExit(f func()) {
+	exitHooksMutex.Lock()
+	defer exitHooksMutex.Unlock()
+	exitHooks = append(exitHooks, f)
+}
+
+// Exit calls the functions registered with AtExit, in reverse order, then
+// exits with the given code. It must be used instead of os.Exit by the code
+// that can run while the terminal is in raw mode.
+func Exit(code int) {
+	runExitHooks()
+	os.Exit(code)
+}
+
+// Fatalf is like Exit(1), printing an error message to stderr once the
+// functions registered with AtExit restored the terminal, so that it's not
+// lost with the dashboard.
+func Fatalf(format string, a ...interface{}) {
+	runExitHooks()
+	fmt.Fprintf(os.Stderr, format, a...)
+	os.Exit(1)
+}
+
+func runExitHooks() {
+	exitHooksMutex.Lock()
+	hooks := exitHooks
+	exitHooks = nil
+	exitHooksMutex.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
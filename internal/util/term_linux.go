@@ -0,0 +1,11 @@
+//go:build linux
+// +build linux
+
+package util
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package util
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoTerminalSupport = errors.New("terminal control not supported on this platform")
+
+func RawInput(f *os.File) (restore func(), err error) {
+	return nil, errNoTerminalSupport
+}
+
+func TerminalSize(f *os.File) (int, int, error) {
+	return 0, 0, errNoTerminalSupport
+}
@@ -2,9 +2,11 @@ package util
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/alranel/arduino-testlib/internal/configuration"
@@ -19,6 +21,29 @@ func LibPathFromName(name string) string {
 	return path.Join(LibrariesDirectory(), utils.SanitizeName(name))
 }
 
+// ManifestPath returns the path of the file where installall keeps track of
+// the installed libraries.
+func ManifestPath() string {
+	return path.Join(configuration.CLIDataDir, "library_manifest.json")
+}
+
+// ChangelogPath returns the path of the file describing the changes applied
+// by the last installall run.
+func ChangelogPath() string {
+	return path.Join(configuration.CLIDataDir, "library_changelog.json")
+}
+
+// QuarantineDirectory returns the directory where libraries removed from the
+// index are moved to.
+func QuarantineDirectory() string {
+	return path.Join(configuration.CLIDataDir, "quarantine")
+}
+
+// MirrorURL returns the URL of a file in the configured mirror.
+func MirrorURL(file string) string {
+	return strings.TrimSuffix(configuration.Mirror, "/") + "/" + file
+}
+
 func CoreFromFQBN(fqbn string) string {
 	return strings.Join(strings.Split(fqbn, ":")[0:2], ":")
 }
@@ -39,6 +64,33 @@ func CoreInArchitectures(core string, architectures []string) bool {
 	return false
 }
 
+// WriteFileAtomic writes data to a temporary file in the same directory and
+// renames it to the destination, so that readers never see a partially
+// written file and an interruption never leaves a truncated one behind.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
 func runCLI(cliCmd []string) bool {
 	fmt.Println("==> " + strings.Join(cliCmd, " "))
 	cmd := exec.Command(cliCmd[0], cliCmd[1:]...)
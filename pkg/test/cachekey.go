@@ -0,0 +1,59 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// hashTree returns a hash of the contents of all the files in a directory,
+// along with their paths.
+func hashTree(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fmt.Fprintf(h, "%s %d\n", filepath.ToSlash(rel), info.Size())
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheKey combines everything that determines the outcome of a test into a
+// single hash: the library contents, the generated sketch, the FQBN with its
+// options, the platform identity, the versions of the libraries it depends
+// on and the compiler warnings level.
+func cacheKey(treeHash string, sketch string, fqbn string, platformIdentity string, dependencies map[string]string, warnings string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "library %s\nfqbn %s\nwarnings %s\n", treeHash, fqbn, warnings)
+	var names []string
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "depends %q %q\n", name, dependencies[name])
+	}
+	fmt.Fprintf(h, "sketch %d\n%s", len(sketch), sketch)
+	fmt.Fprintf(h, "platform %d\n%s", len(platformIdentity), platformIdentity)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alranel/arduino-testlib/internal/configuration"
+	"github.com/alranel/arduino-testlib/internal/util"
+)
+
+func TestHashTree(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func() string {
+		h, err := hashTree(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	write("library.properties", "name=Foo\n")
+	write("src/Foo.h", "void foo();\n")
+	first := hash()
+	if again := hash(); again != first {
+		t.Errorf("hash changed without changes: %s, %s", first, again)
+	}
+
+	write("src/Foo.h", "void bar();\n")
+	changed := hash()
+	if changed == first {
+		t.Errorf("hash unchanged after editing a file")
+	}
+
+	// Moving a file changes the hash, even if the contents are the same
+	os.Remove(filepath.Join(dir, "src/Foo.h"))
+	write("Foo.h", "void bar();\n")
+	if moved := hash(); moved == changed {
+		t.Errorf("hash unchanged after moving a file")
+	}
+
+	if _, err := hashTree(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("hashing a missing directory succeeded")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	const tree, sketch, fqbn, platform, warnings = "abc", "#include <Foo.h>\n", "arduino:avr:uno", "arduino:avr@1.8.5", ""
+	deps := map[string]string{"Bar": "1.0.0", "Baz": ""}
+	key := cacheKey(tree, sketch, fqbn, platform, deps, warnings)
+	if key != cacheKey(tree, sketch, fqbn, platform, map[string]string{"Baz": "", "Bar": "1.0.0"}, warnings) {
+		t.Errorf("cache key is not deterministic")
+	}
+	for _, other := range []string{
+		cacheKey("abd", sketch, fqbn, platform, deps, warnings),
+		cacheKey(tree, "#include <Bar.h>\n", fqbn, platform, deps, warnings),
+		cacheKey(tree, sketch, "arduino:avr:uno:cpu=atmega328old", platform, deps, warnings),
+		cacheKey(tree, sketch, fqbn, "arduino:avr@1.8.6", deps, warnings),
+		cacheKey(tree, sketch, fqbn, platform, map[string]string{"Bar": "1.0.1", "Baz": ""}, warnings),
+		cacheKey(tree, sketch, fqbn, platform, map[string]string{"Bar": "1.0.0", "Baz": "1.0.0"}, warnings),
+		cacheKey(tree, sketch, fqbn, platform, map[string]string{"Bar": "1.0.0"}, warnings),
+		cacheKey(tree, sketch, fqbn, platform, deps, "all"),
+	} {
+		if other == key {
+			t.Errorf("different inputs give the same cache key %s", key)
+		}
+	}
+}
+
+// installLibrary writes the library.properties file of a library in the
+// libraries directory.
+func installLibrary(t *testing.T, name string, version string, depends string) {
+	dir := util.LibPathFromName(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	properties := "name=" + name + "\nversion=" + version + "\ndepends=" + depends + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "library.properties"), []byte(properties), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDependencyChangeRetests(t *testing.T) {
+	userDir := configuration.CLIUserDir
+	configuration.CLIUserDir = t.TempDir()
+	defer func() { configuration.CLIUserDir = userDir }()
+
+	// Bar is resolved through Foo's dependencies, Missing isn't installed
+	installLibrary(t, "Foo", "1.0.0", "Bar (>=1.0.0), Missing")
+	installLibrary(t, "Bar", "1.0.0", "Foo")
+	want := map[string]string{"Foo": "1.0.0", "Bar": "1.0.0", "Missing": ""}
+	if got := resolveDependencies([]string{"Foo"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveDependencies = %v, want %v", got, want)
+	}
+
+	const fqbn, platform = "arduino:avr:uno", "arduino:avr@1.8.5"
+	key := func() string {
+		return cacheKey("abc", "#include <Lib.h>\n", fqbn, platform, resolveDependencies([]string{"Foo"}), "")
+	}
+	tests := []TestResult{{Version: "1.0.0", FQBN: fqbn, CoreVersion: "1.8.5", CacheKey: key()}}
+	if i, _ := cachedTest(tests, fqbn, "1.0.0", "1.8.5", key(), nil); i != 0 {
+		t.Errorf("unchanged dependencies: cachedTest = %d, want 0", i)
+	}
+
+	// Updating an indirect dependency, while the tested library keeps its
+	// version, re-tests it
+	installLibrary(t, "Bar", "1.1.0", "Foo")
+	if i, _ := cachedTest(tests, fqbn, "1.0.0", "1.8.5", key(), nil); i != -1 {
+		t.Errorf("updated dependency: cachedTest = %d, want -1", i)
+	}
+}
+
+func TestCachedTestUsedLibraries(t *testing.T) {
+	const fqbn = "arduino:avr:uno"
+	tests := []TestResult{
+		{Version: "0.9.0", FQBN: fqbn, CoreVersion: "1.8.5"},
+		{Version: "1.0.0", FQBN: fqbn, CoreVersion: "1.8.5", UsedLibraries: map[string]string{"Wire": "1.0"}},
+	}
+	// Tests recorded without a cache key are matched on the versions
+	if i, changed := cachedTest(tests, fqbn, "1.0.0", "1.8.5", "abc", map[string]string{"Wire": "1.0"}); i != 1 || changed != nil {
+		t.Errorf("cachedTest = %d, %v, want 1", i, changed)
+	}
+	if i, changed := cachedTest(tests, fqbn, "1.0.0", "1.8.5", "abc", map[string]string{"Wire": "1.1"}); i != -1 || !reflect.DeepEqual(changed, []string{"Wire"}) {
+		t.Errorf("cachedTest = %d, %v, want -1, [Wire]", i, changed)
+	}
+	if i, _ := cachedTest(tests, fqbn, "1.0.0", "1.8.6", "", nil); i != -1 {
+		t.Errorf("cachedTest on another core version = %d, want -1", i)
+	}
+}
@@ -0,0 +1,69 @@
+package test
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is an error or warning reported by the compiler.
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"` // error, warning or note
+	Message  string `json:"message"`
+	Flag     string `json:"flag,omitempty"` // the warning option, eg. -Wunused-variable
+}
+
+var diagnosticRegexp = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)? (fatal error|error|warning|note): (.*?)(?: \[(-W[^\]]+)\])?$`)
+
+// ParseDiagnostics extracts the diagnostics from a GCC compilation log.
+func ParseDiagnostics(log string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, line := range strings.Split(log, "\n") {
+		m := diagnosticRegexp.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+		d := Diagnostic{
+			File:     m[1],
+			Severity: m[4],
+			Message:  m[5],
+			Flag:     m[6],
+		}
+		if d.Severity == "fatal error" {
+			d.Severity = "error"
+		}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+// FirstError returns a one-line description of the first error in a
+// compilation log, or its first line if no errors can be recognized.
+func FirstError(log string) string {
+	for _, d := range ParseDiagnostics(log) {
+		if d.Severity == "error" {
+			return fmt.Sprintf("%s:%d: %s", filepath.Base(d.File), d.Line, d.Message)
+		}
+	}
+	var first string
+	for _, line := range strings.Split(log, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.Contains(strings.ToLower(line), "error") {
+			return line
+		}
+		if first == "" {
+			first = line
+		}
+	}
+	return first
+}
@@ -0,0 +1,46 @@
+package test
+
+import "testing"
+
+// gccLog was produced by GCC with -Wall -Wextra.
+const gccLog = `/home/user/Arduino/libraries/Foo/src/Foo.cpp: In member function 'int Foo::read(int)':
+/home/user/Arduino/libraries/Foo/src/Foo.cpp:4:11: warning: suggest parentheses around assignment used as truth value [-Wparentheses]
+    4 |   if (pin = 3) {
+      |       ~~~~^~~
+/home/user/Arduino/libraries/Foo/src/Foo.cpp:5:12: error: 'missing' was not declared in this scope
+    5 |     return missing(pin);
+      |            ^~~~~~~
+/home/user/Arduino/libraries/Foo/src/Bar.cpp:1:6: note: declared here
+    1 | void g(int x);
+      |      ^
+/home/user/Arduino/libraries/Foo/src/Foo.h:1:10: fatal error: Wire.h: No such file or directory
+compilation terminated.
+Error during build: exit status 1
+`
+
+func TestParseDiagnostics(t *testing.T) {
+	want := []Diagnostic{
+		{File: "/home/user/Arduino/libraries/Foo/src/Foo.cpp", Line: 4, Column: 11, Severity: "warning", Message: "suggest parentheses around assignment used as truth value", Flag: "-Wparentheses"},
+		{File: "/home/user/Arduino/libraries/Foo/src/Foo.cpp", Line: 5, Column: 12, Severity: "error", Message: "'missing' was not declared in this scope"},
+		{File: "/home/user/Arduino/libraries/Foo/src/Bar.cpp", Line: 1, Column: 6, Severity: "note", Message: "declared here"},
+		{File: "/home/user/Arduino/libraries/Foo/src/Foo.h", Line: 1, Column: 10, Severity: "error", Message: "Wire.h: No such file or directory"},
+	}
+	got := ParseDiagnostics(gccLog)
+	if len(got) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("diagnostic %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFirstError(t *testing.T) {
+	if got, want := FirstError(gccLog), "Foo.cpp:5: 'missing' was not declared in this scope"; got != want {
+		t.Errorf("FirstError = %q, want %q", got, want)
+	}
+	if got, want := FirstError("\nsomething went wrong\n"), "something went wrong"; got != want {
+		t.Errorf("FirstError = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,38 @@
+package test
+
+// HistoryEntry is the compatibility status of a library/board pair as tested
+// by a run.
+type HistoryEntry struct {
+	Run    string              `json:"run"`
+	FQBN   string              `json:"fqbn"`
+	Status CompatibilityStatus `json:"status"`
+}
+
+// AddHistory records the status of a test in the history of the library,
+// unless the test was recorded without a run or is already there.
+func (tr *TestResults) AddHistory(t TestResult) {
+	if t.Run == "" {
+		return
+	}
+	tr.addHistoryEntry(HistoryEntry{Run: t.Run, FQBN: t.FQBN, Status: t.Status()})
+}
+
+func (tr *TestResults) addHistoryEntry(e HistoryEntry) {
+	for _, h := range tr.History {
+		if h.Run == e.Run && h.FQBN == e.FQBN {
+			return
+		}
+	}
+	tr.History = append(tr.History, e)
+}
+
+// RunHistory returns the status of the library/board pairs in each run that
+// tested them: the history, and the tests recorded with a run which are not
+// part of it, since they were recorded before the history was kept.
+func (tr *TestResults) RunHistory() []HistoryEntry {
+	h := TestResults{History: append([]HistoryEntry{}, tr.History...)}
+	for _, t := range tr.Tests {
+		h.AddHistory(t)
+	}
+	return h.History
+}
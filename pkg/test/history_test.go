@@ -0,0 +1,57 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRunHistory(t *testing.T) {
+	tr := TestResults{
+		Name: "Foo",
+		Tests: []TestResult{
+			{Version: "1.0.0", FQBN: "arduino:avr:uno", Core: "arduino:avr", Architectures: []string{"avr"}, Result: PASS, Run: "2022-05-02T00:00:00Z"},
+			{Version: "1.0.0", FQBN: "arduino:samd:mkr1000", Core: "arduino:samd", Architectures: []string{"avr"}, Result: PASS},
+		},
+		History: []HistoryEntry{
+			{Run: "2022-05-01T00:00:00Z", FQBN: "arduino:avr:uno", Status: FAIL_CLAIM},
+			{Run: "2022-05-02T00:00:00Z", FQBN: "arduino:avr:uno", Status: PASS_CLAIM},
+		},
+	}
+	want := []HistoryEntry{
+		{Run: "2022-05-01T00:00:00Z", FQBN: "arduino:avr:uno", Status: FAIL_CLAIM},
+		{Run: "2022-05-02T00:00:00Z", FQBN: "arduino:avr:uno", Status: PASS_CLAIM},
+	}
+	if got := tr.RunHistory(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RunHistory = %+v, want %+v", got, want)
+	}
+
+	// Tests recorded before the history was kept are included
+	tr.History = nil
+	want = want[1:]
+	if got := tr.RunHistory(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RunHistory = %+v, want %+v", got, want)
+	}
+}
+
+func TestMergeResultsHistory(t *testing.T) {
+	old := TestResult{Version: "1.0.0", FQBN: "arduino:avr:uno", Core: "arduino:avr", CoreVersion: "1.8.5", Architectures: []string{"avr"}, Result: FAIL, Run: "2022-05-01T00:00:00Z"}
+	retest := old
+	retest.Result, retest.Run = PASS, "2022-05-02T00:00:00Z"
+
+	dst := TestResults{Name: "Foo", Tests: []TestResult{old}}
+	src := TestResults{Name: "Foo", Tests: []TestResult{retest}}
+	merged, err := MergeResults(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Tests) != 1 || merged.Tests[0].Result != PASS {
+		t.Errorf("tests = %+v, want the re-test only", merged.Tests)
+	}
+	want := []HistoryEntry{
+		{Run: "2022-05-01T00:00:00Z", FQBN: "arduino:avr:uno", Status: FAIL_CLAIM},
+		{Run: "2022-05-02T00:00:00Z", FQBN: "arduino:avr:uno", Status: PASS_CLAIM},
+	}
+	if !reflect.DeepEqual(merged.History, want) {
+		t.Errorf("history = %+v, want %+v", merged.History, want)
+	}
+}
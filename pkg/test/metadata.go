@@ -0,0 +1,116 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// Library layouts, as defined by the library specification
+const (
+	LayoutFlat = "flat" // sources in the root directory (1.0 format)
+	LayoutSrc  = "src"  // sources in the src directory (1.5 format)
+)
+
+// Metadata describes a library version. It's read from its library.properties
+// and its contents when the library is tested.
+type Metadata struct {
+	Author     string   `json:"author,omitempty"`
+	Maintainer string   `json:"maintainer,omitempty"`
+	Sentence   string   `json:"sentence,omitempty"`
+	Category   string   `json:"category,omitempty"`
+	URL        string   `json:"url,omitempty"`
+	Depends    []string `json:"depends,omitempty"`  // as in library.properties, with the version constraints
+	Includes   []string `json:"includes,omitempty"` // the headers to include, if declared
+	HasLicense bool     `json:"has_license,omitempty"`
+	Layout     string   `json:"layout,omitempty"` // LayoutFlat or LayoutSrc; empty if unknown
+}
+
+// readMetadata reads the metadata of the library in libPath.
+func readMetadata(libPath string, properties *ini.File) Metadata {
+	key := func(name string) string {
+		return strings.TrimSpace(properties.Section("").Key(name).String())
+	}
+	m := Metadata{
+		Author:     key("author"),
+		Maintainer: key("maintainer"),
+		Sentence:   key("sentence"),
+		Category:   key("category"),
+		URL:        key("url"),
+		Depends:    splitList(key("depends")),
+		Includes:   splitList(key("includes")),
+		Layout:     LayoutFlat,
+	}
+	if info, err := os.Stat(path.Join(libPath, "src")); err == nil && info.IsDir() {
+		m.Layout = LayoutSrc
+	}
+	files, _ := ioutil.ReadDir(libPath)
+	for _, f := range files {
+		name := strings.ToUpper(f.Name())
+		if !f.IsDir() && (strings.HasPrefix(name, "LICENSE") || strings.HasPrefix(name, "LICENCE") || strings.HasPrefix(name, "COPYING")) {
+			m.HasLicense = true
+		}
+	}
+	return m
+}
+
+// splitList splits a comma-separated list, dropping the empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// versions returns the metadata of each tested version, taken from its last
+// test recording it.
+func (tr TestResults) versions() map[string]Metadata {
+	var versions map[string]Metadata
+	for _, t := range tr.Tests {
+		if t.Layout == "" {
+			continue
+		}
+		if versions == nil {
+			versions = make(map[string]Metadata)
+		}
+		versions[t.Version] = t.Metadata
+	}
+	return versions
+}
+
+// MarshalJSON stores the metadata once per version, in the versions of the
+// results, rather than in each test.
+func (tr TestResults) MarshalJSON() ([]byte, error) {
+	type plain TestResults
+	return json.Marshal(struct {
+		plain
+		Versions map[string]Metadata `json:"versions,omitempty"` // version => metadata
+	}{plain(tr), tr.versions()})
+}
+
+// UnmarshalJSON sets the metadata of each test from the versions of the
+// results.
+func (tr *TestResults) UnmarshalJSON(b []byte) error {
+	type plain TestResults
+	var v struct {
+		plain
+		Versions map[string]Metadata `json:"versions"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*tr = TestResults(v.plain)
+	for i := range tr.Tests {
+		if m, ok := v.Versions[tr.Tests[i].Version]; ok {
+			tr.Tests[i].Metadata = m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMetadataJSON(t *testing.T) {
+	m := Metadata{Author: "Jane", Category: "Sensors", Depends: []string{"Wire"}, HasLicense: true, Layout: LayoutSrc}
+	tr := TestResults{
+		Name: "Foo",
+		Tests: []TestResult{
+			{Version: "1.0.0", FQBN: "arduino:avr:uno", Examples: []ExampleResult{}, Metadata: m},
+			{Version: "1.0.0", FQBN: "arduino:samd:mkr1000", Examples: []ExampleResult{}, Metadata: m},
+			{Version: "0.9.0", FQBN: "arduino:avr:uno", Examples: []ExampleResult{}},
+		},
+	}
+	b, err := json.Marshal(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The metadata is stored once, in the versions
+	if n := strings.Count(string(b), `"Jane"`); n != 1 {
+		t.Errorf("metadata stored %d times: %s", n, b)
+	}
+
+	var got TestResults
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, tr) {
+		t.Errorf("round trip = %+v, want %+v", got, tr)
+	}
+}
@@ -2,13 +2,17 @@ package test
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/alranel/arduino-testlib/internal/cliclient"
 	"github.com/alranel/arduino-testlib/internal/configuration"
@@ -24,10 +28,24 @@ const (
 	FAIL CompilationResult = "FAIL"
 )
 
-type exampleResult struct {
-	Name   string            `json:"name"`
-	Result CompilationResult `json:"result"`
-	Log    string            `json:"log"`
+// CompatibilityStatus compares the result of a test with the compatibility
+// declared by the library.
+type CompatibilityStatus string
+
+const (
+	PASS_CLAIM   CompatibilityStatus = "PASS_CLAIM"
+	PASS_NOCLAIM CompatibilityStatus = "PASS_NOCLAIM"
+	FAIL_CLAIM   CompatibilityStatus = "FAIL_CLAIM"
+	FAIL_NOCLAIM CompatibilityStatus = "FAIL_NOCLAIM"
+)
+
+type ExampleResult struct {
+	Name    string            `json:"name"`
+	Result  CompilationResult `json:"result"`
+	Log     string            `json:"log,omitempty"`
+	LogHash string            `json:"log_hash,omitempty"` // when the log is stored separately
+
+	UsedLibraries map[string]string `json:"used_libraries,omitempty"` // name => version
 }
 
 type TestResult struct {
@@ -37,22 +55,204 @@ type TestResult struct {
 	Core          string            `json:"core"`
 	CoreVersion   string            `json:"core_version"`
 	Result        CompilationResult `json:"result"`
-	Log           string            `json:"log"`
-	Examples      []exampleResult   `json:"examples"`
+	Log           string            `json:"log,omitempty"`
+	LogHash       string            `json:"log_hash,omitempty"` // when the log is stored separately
+	Examples      []ExampleResult   `json:"examples"`
 	NoMainHeader  bool              `json:"no_main_header"`
+	Duration      float64           `json:"duration,omitempty"` // seconds
+	Run           string            `json:"run,omitempty"`
+
+	// UsedLibraries lists the libraries from the user directory used by
+	// the compilation. Since arduino-cli only reports them for successful
+	// compilations, failing ones list the dependencies of the library
+	// instead, see dependencyVersions
+	UsedLibraries map[string]string `json:"used_libraries,omitempty"` // name => version
+
+	// CacheKey is a hash of the library contents, the sketch, the FQBN, the
+	// platform, the versions of the dependencies and the warnings level,
+	// deciding whether the test needs to be repeated
+	CacheKey string `json:"cache_key,omitempty"`
+
+	// Metadata of the tested library version; unknown for the tests
+	// recorded by older versions of this tool. In JSON it's stored once per
+	// version, see TestResults.MarshalJSON
+	Metadata `json:"-"`
 }
 
 type TestResults struct {
 	Name  string       `json:"name"`
 	Tests []TestResult `json:"tests"`
+
+	// History keeps the status of the library/board pairs in each run that
+	// tested them, also after their results were replaced by a re-test
+	History []HistoryEntry `json:"history,omitempty"`
+}
+
+// Status returns the compatibility status of the test, according to the
+// architectures declared by the library.
+func (t *TestResult) Status() CompatibilityStatus {
+	claim := util.CoreInArchitectures(t.Core, t.Architectures)
+	switch {
+	case t.Result == PASS && claim:
+		return PASS_CLAIM
+	case t.Result == PASS:
+		return PASS_NOCLAIM
+	case claim:
+		return FAIL_CLAIM
+	}
+	return FAIL_NOCLAIM
+}
+
+// ChangedLibraries returns the libraries used by the test or by its examples
+// whose installed version differs from the one used, including the ones no
+// longer installed. installed maps library names to versions.
+func (t *TestResult) ChangedLibraries(installed map[string]string) []string {
+	changed := make(map[string]bool)
+	check := func(used map[string]string) {
+		for name, version := range used {
+			if installed[name] != version {
+				changed[name] = true
+			}
+		}
+	}
+	check(t.UsedLibraries)
+	for _, e := range t.Examples {
+		check(e.UsedLibraries)
+	}
+	var names []string
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveDependencies returns the libraries the library depends on,
+// recursively, with the version declared by their library.properties file,
+// or an empty version for the ones not installed.
+func resolveDependencies(depends []string) map[string]string {
+	versions := make(map[string]string)
+	var visit func(depends []string)
+	visit = func(depends []string) {
+		for _, dep := range depends {
+			// Strip the version constraint, as in "Servo (>=1.1.0)"
+			name := strings.TrimSpace(strings.SplitN(dep, "(", 2)[0])
+			if _, seen := versions[name]; seen || name == "" {
+				continue
+			}
+			versions[name] = ""
+			properties, err := ini.Load(path.Join(util.LibPathFromName(name), "library.properties"))
+			if err == nil {
+				versions[name] = properties.Section("").Key("version").String()
+				visit(splitList(properties.Section("").Key("depends").String()))
+			}
+		}
+	}
+	visit(depends)
+	return versions
+}
+
+// dependencyVersions returns the installed versions of the resolved
+// dependencies of a library, with an empty version for the ones not
+// installed. They stand for the libraries used by failing compilations, which
+// arduino-cli doesn't report, so that failures are re-tested when their
+// dependencies are installed or updated. It returns nil if the installed
+// libraries are unknown.
+func dependencyVersions(resolved map[string]string, installed map[string]string) map[string]string {
+	if installed == nil || len(resolved) == 0 {
+		return nil
+	}
+	versions := make(map[string]string)
+	for name := range resolved {
+		versions[name] = installed[name]
+	}
+	return versions
+}
+
+// cachedTest returns the index of the recorded test of a combo that is still
+// valid, or -1 if the combo must be tested. Tests are matched on the cache
+// key, or on the library and core versions for tests recorded without one.
+// A test is not valid if any of the libraries it used changed, as listed by
+// changed; installed maps the installed libraries to their versions, if
+// known.
+func cachedTest(tests []TestResult, fqbn string, version string, coreVersion string, key string, installed map[string]string) (index int, changed []string) {
+	for i, t := range tests {
+		if t.FQBN != fqbn {
+			continue
+		}
+		matches := t.Version == version && t.CoreVersion == coreVersion
+		if t.CacheKey != "" && key != "" {
+			matches = t.CacheKey == key
+		}
+		if !matches {
+			continue
+		}
+		if installed != nil {
+			if changed := t.ChangedLibraries(installed); len(changed) > 0 {
+				return -1, changed
+			}
+		}
+		return i, nil
+	}
+	return -1, nil
 }
 
-func TestLibByName(libName string, tr TestResults, force bool, instance *cliclient.CliInstance) TestResults {
+// Options control how libraries are tested.
+type Options struct {
+	// Force re-tests the combinations that were already tested
+	Force bool
+
+	// FQBNs restricts the tests to some of the configured boards; all of
+	// them are tested if empty
+	FQBNs []string
+
+	// InstalledLibraries maps the installed libraries to their versions.
+	// When set, the combinations already tested are re-tested if any of the
+	// libraries used by the compilation changed
+	InstalledLibraries map[string]string
+
+	// Warnings is the compiler warnings level, as in arduino-cli compile
+	// --warnings. Warnings aren't shown if empty
+	Warnings string
+
+	// Run identifies the run the tests belong to, and is recorded in the
+	// results. It's the start time of the run in RFC 3339 format
+	Run string
+
+	// Progress receives the progress messages; they are written to stdout
+	// if nil
+	Progress io.Writer
+
+	// OnCompileStart, if set, is called before each compilation, with the
+	// result fields left empty
+	OnCompileStart func(CompileEvent)
+
+	// OnCompile, if set, is called after each compilation
+	OnCompile func(CompileEvent)
+}
+
+// CompileEvent describes a completed compilation.
+type CompileEvent struct {
+	Library  string            `json:"library"`
+	Version  string            `json:"version"`
+	FQBN     string            `json:"fqbn"`
+	Example  string            `json:"example,omitempty"` // empty for the library inclusion
+	Result   CompilationResult `json:"result"`
+	Duration float64           `json:"duration"`        // seconds
+	Error    string            `json:"error,omitempty"` // the first error of a failed compilation
+	Log      string            `json:"-"`
+}
+
+func TestLibByName(libName string, tr TestResults, opts Options, instance *cliclient.CliInstance) TestResults {
 	libPath := util.LibPathFromName(libName)
-	return TestLib(libPath, tr, force, instance)
+	return TestLib(libPath, tr, opts, instance)
 }
 
-func TestLib(libPath string, tr TestResults, force bool, instance *cliclient.CliInstance) TestResults {
+func TestLib(libPath string, tr TestResults, opts Options, instance *cliclient.CliInstance) TestResults {
+	progress := opts.Progress
+	if progress == nil {
+		progress = os.Stdout
+	}
 	libPath, _ = filepath.Abs(libPath)
 	if _, err := os.Stat(libPath); err != nil {
 		fmt.Fprintf(os.Stderr, "Library not found in directory: %s\n", libPath)
@@ -71,7 +271,7 @@ func TestLib(libPath string, tr TestResults, force bool, instance *cliclient.Cli
 	architectures := strings.Split(properties.Section("").Key("architectures").String(), ",")
 	includes := strings.Split(properties.Section("").Key("includes").String(), ",")
 	if name == "" {
-		fmt.Printf("No library name found in library.properties: %s\n", libPath)
+		fmt.Fprintf(progress, "No library name found in library.properties: %s\n", libPath)
 		return tr
 	}
 
@@ -80,7 +280,32 @@ func TestLib(libPath string, tr TestResults, force bool, instance *cliclient.Cli
 		return tr
 	}
 	tr.Name = name
-	fmt.Printf("[%s] Start testing\n", nameAndVersion)
+	fmt.Fprintf(progress, "[%s] Start testing\n", nameAndVersion)
+	metadata := readMetadata(libPath, properties)
+	resolved := resolveDependencies(metadata.Depends)
+	dependencies := dependencyVersions(resolved, opts.InstalledLibraries)
+
+	compiling := func(fqbn string, example string) {
+		if opts.OnCompileStart != nil {
+			opts.OnCompileStart(CompileEvent{Library: name, Version: version, FQBN: fqbn, Example: example})
+		}
+	}
+	compiled := func(fqbn string, example string, res CompilationResult, t0 time.Time, out string) {
+		if opts.OnCompile == nil {
+			return
+		}
+		e := CompileEvent{Library: name, Version: version, FQBN: fqbn, Example: example, Result: res, Duration: time.Since(t0).Seconds(), Log: out}
+		if res == FAIL {
+			e.Error = FirstError(out)
+		}
+		opts.OnCompile(e)
+	}
+
+	// Hash the library contents, before any header file is generated
+	treeHash, err := hashTree(libPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] Could not hash library contents: %v\n", nameAndVersion, err)
+	}
 
 	// Look for a main header file
 	headerFile := utils.SanitizeName(name) + ".h"
@@ -91,9 +316,10 @@ func TestLib(libPath string, tr TestResults, force bool, instance *cliclient.Cli
 		// otherwise just create an empty header file. This will still allow the
 		// compilation of .cpp files
 		if _, err := os.Stat(path.Join(libPath, headerFile)); err != nil {
-			fmt.Printf("[%s] Main header file not found, creating an empty one: %s\n", nameAndVersion, headerFile)
+			fmt.Fprintf(progress, "[%s] Main header file not found, creating an empty one: %s\n", nameAndVersion, headerFile)
 			os.Create(headerFilePath)
 			headerFileCreated = true
+			defer os.Remove(headerFilePath)
 		}
 	}
 
@@ -101,7 +327,7 @@ func TestLib(libPath string, tr TestResults, force bool, instance *cliclient.Cli
 	tmpDir, err := ioutil.TempDir("/tmp", "arduino-testlib")
 	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 	defer os.RemoveAll(tmpDir)
 	sketchDir := path.Join(tmpDir, "test")
@@ -126,42 +352,67 @@ func TestLib(libPath string, tr TestResults, force bool, instance *cliclient.Cli
 	f.Close()
 
 	// Try to compile the sketch
+	fqbns := configuration.FQBNs
+	if len(opts.FQBNs) > 0 {
+		fqbns = opts.FQBNs
+	}
 fqbn:
-	for _, fqbn := range configuration.FQBNs {
+	for _, fqbn := range fqbns {
 		core := util.CoreFromFQBN(fqbn)
 		coreVersion, err := instance.GetInstalledCoreVersion(core)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "[%s] Failed to get core version for %s: %v\n", nameAndVersion, core, err)
-			os.Exit(1)
+			util.Exit(1)
+		}
+
+		// Compute the cache key of this combo
+		var key string
+		if platformIdentity, err := instance.GetPlatformIdentity(fqbn); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Could not identify the platform for %s: %v\n", nameAndVersion, fqbn, err)
+		} else if treeHash != "" {
+			key = cacheKey(treeHash, sketch, fqbn, platformIdentity, resolved, opts.Warnings)
 		}
 
 		// Check if this combo was already tested
-		if !force {
-			for _, t := range tr.Tests {
-				if t.Version == version && t.FQBN == fqbn && t.CoreVersion == coreVersion {
-					fmt.Printf("[%s] skipping %s, already tested\n", nameAndVersion, fqbn)
-					continue fqbn
+		if !opts.Force {
+			i, changed := cachedTest(tr.Tests, fqbn, version, coreVersion, key, opts.InstalledLibraries)
+			if len(changed) > 0 {
+				fmt.Fprintf(progress, "[%s] re-testing %s, used libraries changed: %s\n", nameAndVersion, fqbn, strings.Join(changed, ", "))
+			} else if i >= 0 {
+				fmt.Fprintf(progress, "[%s] skipping %s, already tested\n", nameAndVersion, fqbn)
+				if tr.Tests[i].Layout == "" {
+					tr.Tests[i].Metadata = metadata
 				}
+				continue fqbn
 			}
-		} else {
-			// Remove past test results for this combo
+		}
+
+		// Remove past test results for this combo, keeping their status in
+		// the history
+		{
 			var tt []TestResult
 			for _, t := range tr.Tests {
 				if t.Version != version || t.FQBN != fqbn || t.CoreVersion != coreVersion {
 					tt = append(tt, t)
+				} else {
+					tr.AddHistory(t)
 				}
 			}
 			tr.Tests = tt
 		}
 
 		// Test library inclusion
-		resB, out := instance.CompileSketch(sketchDir, libPath, fqbn)
+		t0 := time.Now()
+		compiling(fqbn, "")
+		resB, out, usedLibraries := instance.CompileSketch(sketchDir, libPath, fqbn, opts.Warnings)
 		var res CompilationResult
 		if resB {
 			res = PASS
 		} else {
 			res = FAIL
+			usedLibraries = dependencies
 		}
+		compiled(fqbn, "", res, t0, out)
 
 		// Store results
 		result := TestResult{
@@ -172,35 +423,46 @@ fqbn:
 			CoreVersion:   coreVersion,
 			Log:           out,
 			Result:        res,
-			Examples:      []exampleResult{},
+			Examples:      []ExampleResult{},
 			NoMainHeader:  headerFileCreated,
+			Run:           opts.Run,
+			UsedLibraries: usedLibraries,
+			CacheKey:      key,
+			Metadata:      metadata,
 		}
 
 		// Test examples
 		filepath.Walk(path.Join(libPath, "examples"), func(path string, info fs.FileInfo, err error) error {
 			if err != nil {
-				fmt.Printf("Skipping walk: %s\n", err.Error())
+				fmt.Fprintf(progress, "Skipping walk: %s\n", err.Error())
 				return nil
 			}
 			if strings.HasSuffix(info.Name(), ".ino") {
 				exampleDir := filepath.Dir(path)
-				resB, out := instance.CompileSketch(exampleDir, libPath, fqbn)
+				t1 := time.Now()
+				compiling(fqbn, filepath.Base(exampleDir))
+				resB, out, usedLibraries := instance.CompileSketch(exampleDir, libPath, fqbn, opts.Warnings)
 				var res CompilationResult
 				if resB {
 					res = PASS
 				} else {
 					res = FAIL
+					usedLibraries = dependencies
 				}
-				result.Examples = append(result.Examples, exampleResult{
-					Name:   filepath.Base(exampleDir),
-					Result: res,
-					Log:    out,
+				compiled(fqbn, filepath.Base(exampleDir), res, t1, out)
+				result.Examples = append(result.Examples, ExampleResult{
+					Name:          filepath.Base(exampleDir),
+					Result:        res,
+					Log:           out,
+					UsedLibraries: usedLibraries,
 				})
 			}
 			return nil
 		})
 
+		result.Duration = time.Since(t0).Seconds()
 		tr.Tests = append(tr.Tests, result)
+		tr.AddHistory(result)
 	}
 
 	{
@@ -208,19 +470,142 @@ fqbn:
 		for _, t := range tr.Tests {
 			results = append(results, t.FQBN+"="+string(t.Result))
 		}
-		fmt.Printf("[%s] %s\n", nameAndVersion, strings.Join(results, " "))
+		fmt.Fprintf(progress, "[%s] %s\n", nameAndVersion, strings.Join(results, " "))
 	}
 
 	return tr
 }
 
-func ReadResultsFile(path string, tr *TestResults) bool {
-	jsonFile, err := os.Open(path)
+// ResultsFiles lists the test results files in a datadir, skipping hidden
+// files (such as the testall journal) and temporary files.
+func ResultsFiles(datadirPath string) ([]string, error) {
+	files, err := ioutil.ReadDir(datadirPath)
 	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		paths = append(paths, path.Join(datadirPath, file.Name()))
+	}
+	return paths, nil
+}
+
+// LastTests returns the last test run for each FQBN.
+func (tr *TestResults) LastTests() map[string]TestResult {
+	last := make(map[string]TestResult) // fqbn => test
+	for _, t := range tr.Tests {
+		last[t.FQBN] = t
+	}
+	return last
+}
+
+// Duration returns the time spent testing the library in its last test
+// for each FQBN, or zero if unknown.
+func (tr *TestResults) Duration() time.Duration {
+	var d float64
+	for _, t := range tr.LastTests() {
+		d += t.Duration
+	}
+	return time.Duration(d * float64(time.Second))
+}
+
+// MergeResults adds the tests of src to dst. Tests of the same library
+// version, FQBN and core version found in both are taken from the most
+// recent run, or from src if their runs are the same or unknown; tests
+// recorded without a run are older than the others. The histories are
+// merged, including the tests replaced.
+func MergeResults(dst TestResults, src TestResults) (TestResults, error) {
+	if dst.Name != "" && src.Name != "" && strings.ToLower(dst.Name) != strings.ToLower(src.Name) {
+		return dst, fmt.Errorf("library name mismatch: %s, %s", dst.Name, src.Name)
+	}
+	if dst.Name == "" {
+		dst.Name = src.Name
+	}
+	for _, t := range src.Tests {
+		replaced := false
+		for i, d := range dst.Tests {
+			if d.Version == t.Version && d.FQBN == t.FQBN && d.CoreVersion == t.CoreVersion {
+				if !RunBefore(t.Run, d.Run) {
+					dst.AddHistory(d)
+					dst.Tests[i] = t
+				}
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			dst.Tests = append(dst.Tests, t)
+		}
+	}
+	for _, e := range src.RunHistory() {
+		dst.addHistoryEntry(e)
+	}
+	return dst, nil
+}
+
+// RunBefore checks whether run a started before run b. Runs are compared by
+// time, since they may be recorded with different time zones; a missing run
+// is before any other.
+func RunBefore(a string, b string) bool {
+	if b == "" {
 		return false
 	}
-	defer jsonFile.Close()
-	byteValue, _ := ioutil.ReadAll(jsonFile)
-	json.Unmarshal(byteValue, &tr)
-	return true
+	if a == "" {
+		return true
+	}
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return ta.Before(tb)
+}
+
+// ErrCorruptResults is returned by LoadResultsFile for files that can't be
+// parsed.
+var ErrCorruptResults = errors.New("corrupt test results file")
+
+// ReadResultsFile reads test results from a JSON file, returning false if it
+// can't be read or parsed. Use LoadResultsFile to tell the errors apart.
+func ReadResultsFile(path string, tr *TestResults) bool {
+	return LoadResultsFile(path, tr) == nil
+}
+
+// LoadResultsFile reads test results from a JSON file. A missing file yields
+// an error satisfying os.IsNotExist, a file that can't be parsed an error
+// wrapping ErrCorruptResults.
+func LoadResultsFile(path string, tr *TestResults) error {
+	byteValue, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(byteValue, tr); err != nil {
+		*tr = TestResults{}
+		return fmt.Errorf("%w: %s: %v", ErrCorruptResults, path, err)
+	}
+	return nil
+}
+
+// QuarantineResultsFile moves a corrupt results file to the .corrupt
+// directory of its datadir, where it's kept for inspection but ignored.
+func QuarantineResultsFile(file string) (string, error) {
+	dir := path.Join(path.Dir(file), ".corrupt")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	dest := path.Join(dir, path.Base(file)+"."+time.Now().Format("20060102-150405"))
+	return dest, os.Rename(file, dest)
+}
+
+// WriteResultsFile saves test results to a JSON file. The file is replaced
+// atomically, so that an interruption never leaves a truncated file behind.
+func WriteResultsFile(path string, tr TestResults) error {
+	jsonData, err := json.MarshalIndent(tr, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, jsonData, 0644)
 }
@@ -0,0 +1,34 @@
+package test
+
+import "testing"
+
+func TestMergeResultsByRun(t *testing.T) {
+	result := func(run string, res CompilationResult) TestResult {
+		return TestResult{Version: "1.0.0", FQBN: "arduino:avr:uno", Core: "arduino:avr", CoreVersion: "1.8.5", Result: res, Run: run}
+	}
+	for _, c := range []struct {
+		name     string
+		dst, src TestResult
+		want     CompilationResult
+	}{
+		{"newer src", result("2022-05-01T00:00:00Z", FAIL), result("2022-05-02T00:00:00Z", PASS), PASS},
+		{"older src", result("2022-05-02T00:00:00Z", FAIL), result("2022-05-01T00:00:00Z", PASS), FAIL},
+		{"time zones", result("2022-05-02T01:00:00+02:00", FAIL), result("2022-05-01T23:30:00Z", PASS), PASS},
+		{"same run", result("2022-05-01T00:00:00Z", FAIL), result("2022-05-01T00:00:00Z", PASS), PASS},
+		{"src without run", result("2022-05-01T00:00:00Z", FAIL), result("", PASS), FAIL},
+		{"dst without run", result("", FAIL), result("2022-05-01T00:00:00Z", PASS), PASS},
+		{"no runs", result("", FAIL), result("", PASS), PASS},
+	} {
+		merged, err := MergeResults(TestResults{Name: "Foo", Tests: []TestResult{c.dst}}, TestResults{Name: "Foo", Tests: []TestResult{c.src}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(merged.Tests) != 1 || merged.Tests[0].Result != c.want {
+			t.Errorf("%s: got %+v, want %s", c.name, merged.Tests, c.want)
+		}
+	}
+
+	if _, err := MergeResults(TestResults{Name: "Foo"}, TestResults{Name: "Bar"}); err == nil {
+		t.Errorf("merging different libraries must fail")
+	}
+}